@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	otelecho "github.com/labstack/echo-contrib/otelecho"
+	"github.com/ravilushqa/otelgqlgen"
 
 	"forest-bd-viewer/internal/auth"
 	"forest-bd-viewer/internal/cache"
@@ -17,13 +22,26 @@ import (
 	"forest-bd-viewer/internal/geo"
 	"forest-bd-viewer/internal/graph"
 	"forest-bd-viewer/internal/graph/generated"
+	"forest-bd-viewer/internal/jobs"
+	"forest-bd-viewer/internal/middleware/readonly"
+	"forest-bd-viewer/internal/observability"
 	"forest-bd-viewer/internal/tiles"
 )
 
 func main() {
+	ctx := context.Background()
+
 	// Load configuration
 	cfg := config.Load()
 
+	// Set up tracing (a no-op provider if OTEL_EXPORTER_OTLP_ENDPOINT is unset).
+	shutdownTracing, err := observability.Setup(ctx, cfg.OTELExporterOTLPEndpoint)
+	if err != nil {
+		fmt.Printf("Warning: tracing setup failed, continuing without it: %v\n", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+	defer shutdownTracing(ctx)
+
 	// Initialize database
 	pool := database.NewPool(cfg.DatabaseURL())
 	defer pool.Close()
@@ -42,6 +60,8 @@ func main() {
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(observability.RequestIDMiddleware())
+	e.Use(otelecho.Middleware(observability.ServiceName))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
@@ -61,34 +81,111 @@ func main() {
 	// JWT auth middleware (must run after echo context injection)
 	e.Use(authSvc.Middleware())
 
+	// Read-only (maintenance) mode — short-circuits mutating requests with
+	// 503 while enabled, e.g. during a migration replay from RunMigrations.
+	// "login" stays allowed so operators can still authenticate.
+	roGuard := readonly.New(cfg.ReadOnly, []string{"login"})
+	e.Use(roGuard.Middleware())
+
+	// SIGUSR1 flips read-only mode without a restart.
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGUSR1)
+		for range sigCh {
+			state := roGuard.Toggle()
+			fmt.Printf("Read-only mode toggled to %v via SIGUSR1\n", state)
+		}
+	}()
+
+	// Admin endpoint to flip read-only mode, guarded by JWT role.
+	e.POST("/admin/readonly", func(c echo.Context) error {
+		claims := auth.GetUser(c.Request().Context())
+		if claims == nil || claims.Role != "admin" {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "admin role required"})
+		}
+		var body struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request body"})
+		}
+		if body.Enabled {
+			roGuard.Enable()
+		} else {
+			roGuard.Disable()
+		}
+		return c.JSON(http.StatusOK, echo.Map{"read_only": roGuard.Enabled()})
+	})
+
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]string{
-			"status":   "ok",
-			"database": "connected",
-			"redis":    "connected",
+		return c.JSON(http.StatusOK, map[string]any{
+			"status":    "ok",
+			"database":  "connected",
+			"redis":     "connected",
+			"read_only": roGuard.Enabled(),
 		})
 	})
 
+	// MVT tile endpoints
+	geoQueries := &geo.Queries{
+		DB:          pool,
+		RoleMapping: auth.RoleMapping(cfg.DBRoleMapping),
+		GuestRole:   cfg.DBGuestRole,
+	}
+
+	// Analysis job queue — drains submitAnalysis jobs in the background for
+	// the lifetime of the process; see internal/jobs.
+	jobsManager := jobs.NewManager(geoQueries, redisClient, 0)
+	go jobsManager.Run(ctx)
+
 	// GraphQL endpoint
 	graphqlHandler := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{
-		Resolvers: &graph.Resolver{DB: pool, AuthSvc: authSvc},
+		Resolvers: &graph.Resolver{DB: pool, AuthSvc: authSvc, Jobs: jobsManager},
 	}))
+	graphqlHandler.Use(otelgqlgen.Middleware())
 	e.POST("/graphql", echo.WrapHandler(graphqlHandler))
 	e.GET("/graphql", echo.WrapHandler(graphqlHandler))
 
-	// MVT tile endpoints
-	geoQueries := &geo.Queries{DB: pool}
+	// Prometheus metrics
+	e.GET("/metrics", observability.MetricsHandler())
+
 	tileHandler := tiles.NewHandler(geoQueries, redisClient)
-	e.GET("/tiles/foret/:z/:x/:y", tileHandler.ForestTile)
-	e.GET("/tiles/admin/:layer/:z/:x/:y", tileHandler.AdminTile)
-	e.GET("/tiles/cadastre/:z/:x/:y", tileHandler.CadastreTile)
+	if cfg.ForestPMTilesPath != "" {
+		f, err := os.Open(cfg.ForestPMTilesPath)
+		if err != nil {
+			fmt.Printf("Warning: could not open forest PMTiles archive %q: %v\n", cfg.ForestPMTilesPath, err)
+		} else {
+			src, err := tiles.NewPMTilesSource(f)
+			if err != nil {
+				fmt.Printf("Warning: could not parse forest PMTiles archive %q: %v\n", cfg.ForestPMTilesPath, err)
+			} else {
+				tileHandler.SetForestPMTiles(src)
+			}
+		}
+	}
+	e.POST("/tiles/foret/batch", tileHandler.BatchExport)
+
+	// Dynamic tileset registry — serves forest, cadastre, admin-*, and any
+	// file-backed tilesets registered at runtime, without hardcoded routes.
+	tileHandler.RegisterBuiltins()
+	e.GET("/tiles/:id/:z/:x/:y", tileHandler.DynamicTile)
+	e.GET("/tiles/:id.json", tileHandler.TileJSON)
 
-	// LiDAR CHM image endpoint
+	// LiDAR CHM image endpoint — the pre-baked color-ramp PNG, or (with a
+	// .tif suffix) the raw georeferenced raster for GIS tools.
 	e.GET("/lidar/chm/:id", func(c echo.Context) error {
-		imageID := c.Param("id")
-		imageID = strings.TrimSuffix(imageID, ".png")
-		path, err := geo.ServeCHMImage(imageID)
+		id := c.Param("id")
+		if tifID := strings.TrimSuffix(id, ".tif"); tifID != id {
+			path, err := geo.ServeCHMGeoTIFF(c.Request().Context(), tifID)
+			if err != nil {
+				return c.JSON(http.StatusNotFound, echo.Map{"error": "CHM GeoTIFF not found"})
+			}
+			return c.File(path)
+		}
+
+		imageID := strings.TrimSuffix(id, ".png")
+		path, err := geo.ServeCHMImage(c.Request().Context(), imageID)
 		if err != nil {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "CHM image not found"})
 		}
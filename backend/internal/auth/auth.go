@@ -24,6 +24,9 @@ const UserContextKey contextKey = "user"
 type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
+	// Role, when present, is mapped to a Postgres role by RunAsSessionUser
+	// so row-level security policies apply per the caller's JWT role.
+	Role string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -54,10 +57,17 @@ func (s *Service) CheckPassword(password, hash string) bool {
 }
 
 func (s *Service) GenerateToken(userID, email string) (string, error) {
+	return s.GenerateTokenWithRole(userID, email, "")
+}
+
+// GenerateTokenWithRole is GenerateToken plus a role claim, used by
+// RunAsSessionUser to pick the Postgres role a request's queries run as.
+func (s *Service) GenerateTokenWithRole(userID, email, role string) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(s.expiryHours) * time.Hour)
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
+		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RoleMapping maps a JWT "role" claim to the Postgres role it should run
+// queries as, so row-level security policies defined on that Postgres role
+// actually apply.
+type RoleMapping map[string]string
+
+// RunAsSessionUser acquires a transaction from pool, switches it to the
+// Postgres role mapped from the current request's JWT claims (or guestRole
+// when unauthenticated), and runs fn inside that transaction. app.user_id is
+// also set via SET LOCAL so RLS policies can scope rows to the calling user
+// (e.g. "a landowner only sees their own parcels").
+//
+// fn's query runs with SET LOCAL ROLE and SET LOCAL "app.user_id" in effect
+// only for the lifetime of this transaction — they reset automatically on
+// commit/rollback, so nothing leaks onto a pooled connection reused by a
+// later, differently-authenticated request.
+func RunAsSessionUser(ctx context.Context, pool *pgxpool.Pool, roles RoleMapping, guestRole string, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	role := guestRole
+	var userID string
+	if claims := GetUser(ctx); claims != nil {
+		userID = claims.UserID
+		if mapped, ok := roles[claims.Role]; ok {
+			role = mapped
+		}
+	}
+	if role == "" {
+		role = guestRole
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("auth: beginning session transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op once committed
+
+	// Role names can't be parameterized in SET ROLE; pgx.Identifier quoting
+	// keeps this safe even though role comes from config-controlled input.
+	setRoleSQL := fmt.Sprintf("SET LOCAL ROLE %s", pgx.Identifier{role}.Sanitize())
+	if _, err := tx.Exec(ctx, setRoleSQL); err != nil {
+		return fmt.Errorf("auth: setting session role %q: %w", role, err)
+	}
+	if _, err := tx.Exec(ctx, "SELECT set_config('app.user_id', $1, true)", userID); err != nil {
+		return fmt.Errorf("auth: setting app.user_id: %w", err)
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -20,6 +21,10 @@ func NewRedisClient(addr string) *redis.Client {
 		PoolSize:     20,
 	})
 
+	if err := redisotel.InstrumentTracing(client); err != nil {
+		log.Printf("Warning: redis tracing instrumentation failed: %v", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -20,6 +21,27 @@ type Config struct {
 	BackendPort    string `mapstructure:"BACKEND_PORT"`
 	JWTSecret      string `mapstructure:"JWT_SECRET"`
 	JWTExpiryHours int    `mapstructure:"JWT_EXPIRY_HOURS"`
+
+	// ForestPMTilesPath, when set, points at a pre-baked .pmtiles archive to
+	// serve forest tiles from instead of (as a fallback to) PostGIS.
+	ForestPMTilesPath string `mapstructure:"FOREST_PMTILES_PATH"`
+
+	// DBRoleMapping maps a JWT "role" claim to the Postgres role that
+	// session's queries run as (see auth.RunAsSessionUser). Populated from
+	// DB_ROLE_MAPPING, a JSON object e.g. {"admin":"app_admin","viewer":"app_viewer"}.
+	DBRoleMapping map[string]string
+
+	// DBGuestRole is the Postgres role used for unauthenticated requests or
+	// requests whose JWT role has no entry in DBRoleMapping.
+	DBGuestRole string `mapstructure:"DB_GUEST_ROLE"`
+
+	// OTELExporterOTLPEndpoint is the OTLP/gRPC collector address traces are
+	// exported to (e.g. "localhost:4317"). Leaving it unset disables tracing.
+	OTELExporterOTLPEndpoint string `mapstructure:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+
+	// ReadOnly starts the backend in maintenance (read-only) mode; see
+	// internal/middleware/readonly. It can also be flipped at runtime.
+	ReadOnly bool `mapstructure:"READ_ONLY"`
 }
 
 func (c *Config) DatabaseURL() string {
@@ -48,6 +70,11 @@ func Load() *Config {
 	viper.BindEnv("BACKEND_PORT")
 	viper.BindEnv("JWT_SECRET")
 	viper.BindEnv("JWT_EXPIRY_HOURS")
+	viper.BindEnv("FOREST_PMTILES_PATH")
+	viper.BindEnv("DB_ROLE_MAPPING")
+	viper.BindEnv("DB_GUEST_ROLE")
+	viper.BindEnv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	viper.BindEnv("READ_ONLY")
 
 	// Defaults
 	viper.SetDefault("POSTGRES_HOST", "localhost")
@@ -56,6 +83,7 @@ func Load() *Config {
 	viper.SetDefault("REDIS_PORT", "6379")
 	viper.SetDefault("BACKEND_PORT", "8080")
 	viper.SetDefault("JWT_EXPIRY_HOURS", 24)
+	viper.SetDefault("DB_GUEST_ROLE", "guest")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Printf("Warning: no .env file found, using environment variables")
@@ -66,5 +94,13 @@ func Load() *Config {
 		log.Fatalf("Failed to unmarshal config: %v", err)
 	}
 
+	// DB_ROLE_MAPPING is a JSON object, which viper's flat env binding
+	// doesn't parse on its own, so decode it explicitly.
+	if raw := viper.GetString("DB_ROLE_MAPPING"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.DBRoleMapping); err != nil {
+			log.Fatalf("Failed to parse DB_ROLE_MAPPING as JSON: %v", err)
+		}
+	}
+
 	return cfg
 }
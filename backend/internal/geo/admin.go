@@ -3,6 +3,11 @@ package geo
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"forest-bd-viewer/internal/observability"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // validAdminLayers is the set of permitted layer names for AdminTile.
@@ -40,6 +45,11 @@ func (q *Queries) AdminTile(ctx context.Context, layer string, z, x, y int) ([]b
 		return nil, fmt.Errorf("invalid tile coordinates: z=%d x=%d y=%d", z, x, y)
 	}
 
+	start := time.Now()
+	ctx, span := observability.StartSpan(ctx, "geo.AdminTile", observability.TileAttributes(layer, z, x, y)...)
+	defer span.End()
+	defer func() { observability.ObserveQueryDuration("AdminTile", time.Since(start).Seconds()) }()
+
 	fields := layerFields(layer)
 
 	// Build the query dynamically — layer is validated against the allowlist above,
@@ -63,7 +73,9 @@ func (q *Queries) AdminTile(ctx context.Context, layer string, z, x, y int) ([]b
 	`, layer, fields, layer)
 
 	var tile []byte
-	err := q.DB.QueryRow(ctx, query, z, x, y).Scan(&tile)
+	err := q.withSessionUser(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, z, x, y).Scan(&tile)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("admin tile query failed (layer=%s z=%d x=%d y=%d): %w", layer, z, x, y, err)
 	}
@@ -4,6 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"forest-bd-viewer/internal/geo/filter"
+	"forest-bd-viewer/internal/observability"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // PolygonStats holds the aggregate results of a spatial polygon analysis
@@ -36,24 +45,98 @@ type SpeciesRow struct {
 // All area calculations use ST_Transform to EPSG:2154 (RGF93 Lambert 93),
 // the French official metric projection, so areas are in square metres and
 // converted to hectares (÷ 10000).
-func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*PolygonStats, error) {
-	// Validate that geojson is parseable JSON before sending to PostGIS.
+//
+// f, when non-nil and non-empty, restricts forest_parcels to the matching
+// essences/TFV codes/departements/minimum area before it's aggregated — see
+// the geo/filter package for the expression grammar it's compiled from.
+func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string, f *filter.Filter) (*PolygonStats, error) {
+	start := time.Now()
+	ctx, span := observability.StartSpan(ctx, "geo.AnalyzePolygon")
+	defer span.End()
+	defer func() { observability.ObserveQueryDuration("AnalyzePolygon", time.Since(start).Seconds()) }()
+
+	if err := validatePolygonGeoJSON(geojsonGeom); err != nil {
+		return nil, err
+	}
+
+	var stats *PolygonStats
+	err := q.withSessionUser(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		stats, err = analyzePolygonQueries(ctx, tx, geojsonGeom, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("parcel_count", stats.ParcelCount))
+	return stats, nil
+}
+
+// AnalyzePolygonAsUser is AnalyzePolygon but additionally bounds the query
+// to timeout via SET LOCAL statement_timeout inside the same session
+// transaction. It's used by the analysis job worker pool (internal/jobs),
+// where a single runaway ST_Intersection on a pathological feature
+// shouldn't be able to wedge a worker forever, but — unlike a shared,
+// long-lived connection with its own statement_timeout — each task still
+// has to run under its own submitting caller's row-level-security role
+// rather than whichever role a previous task on that worker happened to use.
+func (q *Queries) AnalyzePolygonAsUser(ctx context.Context, geojsonGeom string, f *filter.Filter, timeout time.Duration) (*PolygonStats, error) {
+	if err := validatePolygonGeoJSON(geojsonGeom); err != nil {
+		return nil, err
+	}
+
+	var stats *PolygonStats
+	err := q.withSessionUser(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+			return fmt.Errorf("setting statement_timeout: %w", err)
+		}
+		var err error
+		stats, err = analyzePolygonQueries(ctx, tx, geojsonGeom, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// validatePolygonGeoJSON checks that geojsonGeom is parseable JSON encoding
+// a Polygon or MultiPolygon geometry.
+func validatePolygonGeoJSON(geojsonGeom string) error {
 	if !json.Valid([]byte(geojsonGeom)) {
-		return nil, fmt.Errorf("invalid GeoJSON: not valid JSON")
+		return fmt.Errorf("invalid GeoJSON: not valid JSON")
 	}
-	// Basic type check — must be Polygon or MultiPolygon.
 	var peek struct {
 		Type string `json:"type"`
 	}
 	if err := json.Unmarshal([]byte(geojsonGeom), &peek); err != nil {
-		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+		return fmt.Errorf("invalid GeoJSON: %w", err)
 	}
 	if peek.Type != "Polygon" && peek.Type != "MultiPolygon" {
-		return nil, fmt.Errorf("invalid GeoJSON: type must be Polygon or MultiPolygon, got %q", peek.Type)
+		return fmt.Errorf("invalid GeoJSON: type must be Polygon or MultiPolygon, got %q", peek.Type)
 	}
+	return nil
+}
+
+// queryExecer is satisfied by pgx.Tx, the per-query transactions
+// withSessionUser hands out, so the three queries below can run against one.
+type queryExecer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// analyzePolygonQueries runs the three PostGIS queries backing
+// AnalyzePolygon/AnalyzePolygonAsUser against db.
+func analyzePolygonQueries(ctx context.Context, db queryExecer, geojsonGeom string, f *filter.Filter) (*PolygonStats, error) {
+	// filterSQL restricts the fp join condition in all three queries below;
+	// args carries geojsonGeom plus whatever parameters filterSQL references.
+	filterSQL, filterArgs := f.Compile(1)
+	args := append([]any{geojsonGeom}, filterArgs...)
+
+	var stats PolygonStats
 
 	// ── 1. Polygon area + forest cover summary ────────────────────────────────
-	const summarySQL = `
+	summarySQL := `
 		WITH poly AS (
 			SELECT ST_GeomFromGeoJSON($1) AS geom
 		)
@@ -67,15 +150,16 @@ func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*Poly
 		LEFT JOIN forest_parcels fp
 			ON fp.geom && poly.geom
 			AND ST_Intersects(fp.geom, poly.geom)
+			` + filterSQL + `
 		GROUP BY poly.geom
 	`
 
-	var stats PolygonStats
-	if err := q.DB.QueryRow(ctx, summarySQL, geojsonGeom).Scan(
+	err := db.QueryRow(ctx, summarySQL, args...).Scan(
 		&stats.AreaHa,
 		&stats.ForestCoverHa,
 		&stats.ParcelCount,
-	); err != nil {
+	)
+	if err != nil {
 		return nil, fmt.Errorf("polygon summary query failed: %w", err)
 	}
 
@@ -88,7 +172,7 @@ func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*Poly
 	// Normalize granular BD Forêt V2 codes (e.g. FF1-09-09, FF1G01-01) and
 	// legacy TFIFN codes (e.g. AFJ, CPV, 30) to the 9 top-level TFV categories
 	// so the frontend can translate and display them consistently.
-	const tfvSQL = `
+	tfvSQL := `
 		WITH poly AS (SELECT ST_GeomFromGeoJSON($1) AS geom),
 		intersected AS (
 			SELECT
@@ -114,6 +198,7 @@ func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*Poly
 			JOIN forest_parcels fp
 				ON fp.geom && poly.geom
 				AND ST_Intersects(fp.geom, poly.geom)
+				` + filterSQL + `
 		)
 		SELECT
 			norm_code AS code_tfv,
@@ -135,25 +220,25 @@ func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*Poly
 		ORDER BY area_ha DESC
 	`
 
-	tfvRows, err := q.DB.Query(ctx, tfvSQL, geojsonGeom)
+	tfvRows, err := db.Query(ctx, tfvSQL, args...)
 	if err != nil {
 		return nil, fmt.Errorf("TFV breakdown query failed: %w", err)
 	}
-	defer tfvRows.Close()
-
 	for tfvRows.Next() {
 		var row TFVRow
 		if err := tfvRows.Scan(&row.CodeTFV, &row.LibTFV, &row.AreaHa); err != nil {
+			tfvRows.Close()
 			return nil, fmt.Errorf("scanning TFV row: %w", err)
 		}
 		stats.TFVBreakdown = append(stats.TFVBreakdown, row)
 	}
+	tfvRows.Close()
 	if err := tfvRows.Err(); err != nil {
-		return nil, fmt.Errorf("iterating TFV rows: %w", err)
+		return nil, fmt.Errorf("TFV breakdown query failed: %w", err)
 	}
 
 	// ── 3. Species breakdown ──────────────────────────────────────────────────
-	const speciesSQL = `
+	speciesSQL := `
 		WITH poly AS (SELECT ST_GeomFromGeoJSON($1) AS geom)
 		SELECT
 			COALESCE(NULLIF(TRIM(fp.essence1), ''), '—') AS essence,
@@ -164,26 +249,91 @@ func (q *Queries) AnalyzePolygon(ctx context.Context, geojsonGeom string) (*Poly
 		JOIN forest_parcels fp
 			ON fp.geom && poly.geom
 			AND ST_Intersects(fp.geom, poly.geom)
+			` + filterSQL + `
 		GROUP BY fp.essence1
 		ORDER BY area_ha DESC
 	`
 
-	specRows, err := q.DB.Query(ctx, speciesSQL, geojsonGeom)
+	specRows, err := db.Query(ctx, speciesSQL, args...)
 	if err != nil {
 		return nil, fmt.Errorf("species breakdown query failed: %w", err)
 	}
-	defer specRows.Close()
-
 	for specRows.Next() {
 		var row SpeciesRow
 		if err := specRows.Scan(&row.Essence, &row.AreaHa); err != nil {
+			specRows.Close()
 			return nil, fmt.Errorf("scanning species row: %w", err)
 		}
 		stats.SpeciesBreakdown = append(stats.SpeciesBreakdown, row)
 	}
+	specRows.Close()
 	if err := specRows.Err(); err != nil {
-		return nil, fmt.Errorf("iterating species rows: %w", err)
+		return nil, fmt.Errorf("species breakdown query failed: %w", err)
 	}
 
 	return &stats, nil
 }
+
+// FeatureStats is one feature's result within a FeatureCollection analysis,
+// keyed by the feature's "id" property (or, when absent, its index in the
+// collection's features array as a string).
+type FeatureStats struct {
+	FeatureID string
+	Stats     *PolygonStats
+	Err       error
+}
+
+// AnalyzeFeatureCollection runs AnalyzePolygonAsUser, bounded by timeout,
+// for every Polygon/MultiPolygon feature in geojsonFC, a GeoJSON
+// FeatureCollection, under the caller identity carried on ctx (see
+// auth.RunAsSessionUser). A feature-level error (invalid geometry, a failed
+// query) is recorded on its FeatureStats rather than aborting the whole
+// collection, so one bad feature doesn't cost the results already computed
+// for the rest.
+//
+// This is the per-feature analysis step the job worker pool (internal/jobs)
+// drives one feature at a time so it can report progress and honor
+// cancellation between features; callers analyzing a handful of features
+// synchronously can also call it directly.
+func (q *Queries) AnalyzeFeatureCollection(ctx context.Context, geojsonFC string, f *filter.Filter, timeout time.Duration) ([]FeatureStats, error) {
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID         json.RawMessage `json:"id,omitempty"`
+			Properties struct {
+				ID json.RawMessage `json:"id,omitempty"`
+			} `json:"properties"`
+			Geometry json.RawMessage `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(geojsonFC), &fc); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON FeatureCollection: %w", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("invalid GeoJSON: type must be FeatureCollection, got %q", fc.Type)
+	}
+
+	results := make([]FeatureStats, len(fc.Features))
+	for i, feat := range fc.Features {
+		featureID := FeatureIDOrIndex(feat.ID, feat.Properties.ID, i)
+		stats, err := q.AnalyzePolygonAsUser(ctx, string(feat.Geometry), f, timeout)
+		results[i] = FeatureStats{FeatureID: featureID, Stats: stats, Err: err}
+	}
+	return results, nil
+}
+
+// FeatureIDOrIndex prefers a feature's top-level "id", falls back to
+// properties.id, and finally the feature's array index.
+func FeatureIDOrIndex(topLevelID, propertiesID json.RawMessage, index int) string {
+	for _, raw := range []json.RawMessage{topLevelID, propertiesID} {
+		if len(raw) == 0 {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil && s != "" {
+			return s
+		}
+		return strings.Trim(string(raw), `"`)
+	}
+	return strconv.Itoa(index)
+}
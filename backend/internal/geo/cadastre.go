@@ -3,6 +3,11 @@ package geo
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"forest-bd-viewer/internal/observability"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // CadastreTile returns a Mapbox Vector Tile (MVT) protobuf for cadastre parcelles
@@ -14,6 +19,11 @@ func (q *Queries) CadastreTile(ctx context.Context, z, x, y int) ([]byte, error)
 		return nil, fmt.Errorf("invalid tile coordinates: z=%d x=%d y=%d", z, x, y)
 	}
 
+	start := time.Now()
+	ctx, span := observability.StartSpan(ctx, "geo.CadastreTile", observability.TileAttributes("cadastre", z, x, y)...)
+	defer span.End()
+	defer func() { observability.ObserveQueryDuration("CadastreTile", time.Since(start).Seconds()) }()
+
 	const query = `
 		SELECT ST_AsMVT(q, 'cadastre', 4096, 'geom')
 		FROM (
@@ -37,7 +47,9 @@ func (q *Queries) CadastreTile(ctx context.Context, z, x, y int) ([]byte, error)
 	`
 
 	var tile []byte
-	err := q.DB.QueryRow(ctx, query, z, x, y).Scan(&tile)
+	err := q.withSessionUser(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, z, x, y).Scan(&tile)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("cadastre tile query failed (z=%d x=%d y=%d): %w", z, x, y, err)
 	}
@@ -0,0 +1,377 @@
+// Package filter implements a small expression language for restricting
+// forest_parcels queries (AnalyzePolygon and the tile endpoints) to a subset
+// of parcels, e.g.:
+//
+//	essence1 in ("CHQ","HEQ") and code_tfv ~ "FF1" and area_ha > 0.5
+//
+// Only the columns in allowedColumns can appear on the left of an
+// expression, and only the operators the parser recognises are accepted, so
+// a compiled Filter is safe to splice into a WHERE clause as parameterized
+// SQL (see Compile).
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter holds a parsed, validated set of restrictions on forest_parcels.
+// The zero value matches every parcel. TFVCodes is an exact set ("in"), and
+// TFVPrefixes a set of prefixes to match against ("~") — a code_tfv clause
+// picks exactly one of the two depending on which operator it used.
+type Filter struct {
+	Essences     []string
+	TFVCodes     []string
+	TFVPrefixes  []string
+	Departements []string
+	MinAreaHa    float64
+	// MinAreaStrict is true when the area_ha clause used ">" rather than
+	// ">=", so Compile excludes parcels at exactly MinAreaHa.
+	MinAreaStrict bool
+}
+
+// IsEmpty reports whether f restricts anything. A nil Filter is empty.
+func (f *Filter) IsEmpty() bool {
+	return f == nil ||
+		(len(f.Essences) == 0 && len(f.TFVCodes) == 0 && len(f.TFVPrefixes) == 0 &&
+			len(f.Departements) == 0 && f.MinAreaHa == 0)
+}
+
+// Compile returns a SQL fragment (starting with " AND ", or "" when f is
+// empty) restricting forest_parcels aliased as fp, plus the positional args
+// to append to the query's existing arguments. paramOffset is the number of
+// $N placeholders already used by the caller's query, so generated
+// placeholders continue from $paramOffset+1.
+func (f *Filter) Compile(paramOffset int) (string, []any) {
+	if f.IsEmpty() {
+		return "", nil
+	}
+
+	var clauses []string
+	var args []any
+	next := func() int {
+		paramOffset++
+		return paramOffset
+	}
+
+	if len(f.Essences) > 0 {
+		placeholders := make([]string, len(f.Essences))
+		for i, v := range f.Essences {
+			placeholders[i] = fmt.Sprintf("$%d", next())
+			args = append(args, v)
+		}
+		clauses = append(clauses, fmt.Sprintf("fp.essence1 IN (%s)", strings.Join(placeholders, ",")))
+	}
+
+	if len(f.TFVCodes) > 0 {
+		placeholders := make([]string, len(f.TFVCodes))
+		for i, v := range f.TFVCodes {
+			placeholders[i] = fmt.Sprintf("$%d", next())
+			args = append(args, v)
+		}
+		clauses = append(clauses, fmt.Sprintf("fp.code_tfv IN (%s)", strings.Join(placeholders, ",")))
+	}
+
+	if len(f.TFVPrefixes) > 0 {
+		prefixes := make([]string, len(f.TFVPrefixes))
+		for i, v := range f.TFVPrefixes {
+			prefixes[i] = fmt.Sprintf("fp.code_tfv LIKE $%d", next())
+			args = append(args, v+"%")
+		}
+		clauses = append(clauses, "("+strings.Join(prefixes, " OR ")+")")
+	}
+
+	if len(f.Departements) > 0 {
+		placeholders := make([]string, len(f.Departements))
+		for i, v := range f.Departements {
+			placeholders[i] = fmt.Sprintf("$%d", next())
+			args = append(args, v)
+		}
+		clauses = append(clauses, fmt.Sprintf("fp.departement IN (%s)", strings.Join(placeholders, ",")))
+	}
+
+	if f.MinAreaHa > 0 {
+		op := ">="
+		if f.MinAreaStrict {
+			op = ">"
+		}
+		clauses = append(clauses, fmt.Sprintf("ST_Area(ST_Transform(fp.geom, 2154)) / 10000.0 %s $%d", op, next()))
+		args = append(args, f.MinAreaHa)
+	}
+
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+// Hash returns a short, stable hex digest of f for use as a Redis cache key
+// suffix, so differently-filtered tiles/analyses don't collide. Empty and
+// nil filters hash to "".
+func (f *Filter) Hash() string {
+	if f.IsEmpty() {
+		return ""
+	}
+
+	essences := append([]string(nil), f.Essences...)
+	tfvCodes := append([]string(nil), f.TFVCodes...)
+	tfvPrefixes := append([]string(nil), f.TFVPrefixes...)
+	departements := append([]string(nil), f.Departements...)
+	sort.Strings(essences)
+	sort.Strings(tfvCodes)
+	sort.Strings(tfvPrefixes)
+	sort.Strings(departements)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "essences=%v;tfv=%v;tfvprefixes=%v;departements=%v;minarea=%g;minareastrict=%v", essences, tfvCodes, tfvPrefixes, departements, f.MinAreaHa, f.MinAreaStrict)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// allowedColumns is the set of expression-language column names the parser
+// accepts on the left-hand side of a clause.
+var allowedColumns = map[string]bool{
+	"essence1":    true,
+	"code_tfv":    true,
+	"departement": true,
+	"area_ha":     true,
+}
+
+// Parse compiles expr into a Filter. An empty (or whitespace-only) expr
+// returns the zero Filter — no restriction.
+func Parse(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return &Filter{}, nil
+	}
+
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+
+	p := &parser{toks: toks}
+	f := &Filter{}
+	if err := p.parseClauses(f); err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	if !p.atEnd() {
+		return nil, fmt.Errorf("filter: unexpected token %q", p.peek())
+	}
+	return f, nil
+}
+
+// ── tokenizer ────────────────────────────────────────────────────────────────
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	tokLParen
+	tokRParen
+	tokComma
+	tokTilde
+	tokGTE
+	tokGT
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+		case c == ',':
+			toks = append(toks, token{tokComma, ","})
+			i++
+		case c == '~':
+			toks = append(toks, token{tokTilde, "~"})
+			i++
+		case c == '>':
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				toks = append(toks, token{tokGTE, ">="})
+				i += 2
+			} else {
+				toks = append(toks, token{tokGT, ">"})
+				i++
+			}
+		case c == '"':
+			j := i + 1
+			for j < len(expr) && expr[j] != '"' {
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string literal")
+			}
+			toks = append(toks, token{tokString, expr[i+1 : j]})
+			i = j + 1
+		case isDigit(c):
+			j := i
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, expr[i:j]})
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, expr[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isIdentChar(c byte) bool  { return isIdentStart(c) || isDigit(c) }
+
+// ── parser ───────────────────────────────────────────────────────────────────
+
+// parser recognises: clause (AND clause)*
+// clause: IDENT "in" "(" STRING ("," STRING)* ")"
+//       | IDENT "~" STRING
+//       | IDENT (">" | ">=") NUMBER
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) atEnd() bool { return p.pos >= len(p.toks) }
+
+func (p *parser) peek() string {
+	if p.atEnd() {
+		return "<eof>"
+	}
+	return p.toks[p.pos].text
+}
+
+func (p *parser) next() (token, bool) {
+	if p.atEnd() {
+		return token{}, false
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *parser) parseClauses(f *Filter) error {
+	if err := p.parseClause(f); err != nil {
+		return err
+	}
+	for !p.atEnd() && strings.EqualFold(p.peek(), "and") {
+		p.pos++
+		if err := p.parseClause(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseClause(f *Filter) error {
+	colTok, ok := p.next()
+	if !ok || colTok.kind != tokIdent {
+		return fmt.Errorf("expected column name, got %q", p.peek())
+	}
+	column := strings.ToLower(colTok.text)
+	if !allowedColumns[column] {
+		return fmt.Errorf("column %q is not filterable", colTok.text)
+	}
+
+	opTok, ok := p.next()
+	if !ok {
+		return fmt.Errorf("expected operator after %q", column)
+	}
+
+	switch {
+	case opTok.kind == tokIdent && strings.EqualFold(opTok.text, "in"):
+		values, err := p.parseStringList()
+		if err != nil {
+			return err
+		}
+		switch column {
+		case "essence1":
+			f.Essences = append(f.Essences, values...)
+		case "code_tfv":
+			f.TFVCodes = append(f.TFVCodes, values...)
+		case "departement":
+			f.Departements = append(f.Departements, values...)
+		default:
+			return fmt.Errorf("column %q does not support \"in\"", column)
+		}
+
+	case opTok.kind == tokTilde:
+		strTok, ok := p.next()
+		if !ok || strTok.kind != tokString {
+			return fmt.Errorf("expected string after ~")
+		}
+		if column != "code_tfv" {
+			return fmt.Errorf("column %q does not support ~", column)
+		}
+		f.TFVPrefixes = append(f.TFVPrefixes, strTok.text)
+
+	case opTok.kind == tokGT || opTok.kind == tokGTE:
+		numTok, ok := p.next()
+		if !ok || numTok.kind != tokNumber {
+			return fmt.Errorf("expected number after %q", opTok.text)
+		}
+		if column != "area_ha" {
+			return fmt.Errorf("column %q does not support %q", column, opTok.text)
+		}
+		v, err := strconv.ParseFloat(numTok.text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q: %w", numTok.text, err)
+		}
+		f.MinAreaHa = v
+		f.MinAreaStrict = opTok.kind == tokGT
+
+	default:
+		return fmt.Errorf("unexpected operator %q after %q", opTok.text, column)
+	}
+
+	return nil
+}
+
+func (p *parser) parseStringList() ([]string, error) {
+	if t, ok := p.next(); !ok || t.kind != tokLParen {
+		return nil, fmt.Errorf("expected ( after \"in\"")
+	}
+	var values []string
+	for {
+		t, ok := p.next()
+		if !ok || t.kind != tokString {
+			return nil, fmt.Errorf("expected string literal in list")
+		}
+		values = append(values, t.text)
+
+		sep, ok := p.next()
+		if !ok {
+			return nil, fmt.Errorf("unterminated list, expected , or )")
+		}
+		if sep.kind == tokRParen {
+			break
+		}
+		if sep.kind != tokComma {
+			return nil, fmt.Errorf("expected , or ) in list, got %q", sep.text)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,161 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	toks, err := tokenize(`essence1 in ("CHQ","HEQ") and code_tfv ~ "FF1" and area_ha >= 0.5`)
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+
+	want := []tokenKind{
+		tokIdent, tokIdent, tokLParen, tokString, tokComma, tokString, tokRParen,
+		tokIdent, tokIdent, tokTilde, tokString,
+		tokIdent, tokIdent, tokGTE, tokNumber,
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("tokenize produced %d tokens, want %d: %+v", len(toks), len(want), toks)
+	}
+	for i, k := range want {
+		if toks[i].kind != k {
+			t.Errorf("token %d kind = %v, want %v (text %q)", i, toks[i].kind, k, toks[i].text)
+		}
+	}
+}
+
+func TestTokenize_GTVsGTE(t *testing.T) {
+	toks, err := tokenize("> >= >")
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	want := []tokenKind{tokGT, tokGTE, tokGT}
+	for i, k := range want {
+		if toks[i].kind != k {
+			t.Errorf("token %d kind = %v, want %v", i, toks[i].kind, k)
+		}
+	}
+}
+
+func TestParse_In(t *testing.T) {
+	f, err := Parse(`essence1 in ("CHQ","HEQ") and departement in ("38")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(f.Essences, []string{"CHQ", "HEQ"}) {
+		t.Errorf("Essences = %v, want [CHQ HEQ]", f.Essences)
+	}
+	if !reflect.DeepEqual(f.Departements, []string{"38"}) {
+		t.Errorf("Departements = %v, want [38]", f.Departements)
+	}
+}
+
+func TestParse_Tilde(t *testing.T) {
+	f, err := Parse(`code_tfv ~ "FF1"`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(f.TFVPrefixes, []string{"FF1"}) {
+		t.Errorf("TFVPrefixes = %v, want [FF1]", f.TFVPrefixes)
+	}
+	if len(f.TFVCodes) != 0 {
+		t.Errorf("TFVCodes = %v, want empty (code_tfv ~ sets TFVPrefixes, not TFVCodes)", f.TFVCodes)
+	}
+}
+
+func TestParse_CodeTFVIn(t *testing.T) {
+	f, err := Parse(`code_tfv in ("FF1-09-09")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(f.TFVCodes, []string{"FF1-09-09"}) {
+		t.Errorf("TFVCodes = %v, want [FF1-09-09]", f.TFVCodes)
+	}
+	if len(f.TFVPrefixes) != 0 {
+		t.Errorf("TFVPrefixes = %v, want empty (code_tfv in sets TFVCodes, not TFVPrefixes)", f.TFVPrefixes)
+	}
+}
+
+func TestParse_AreaHaOperators(t *testing.T) {
+	cases := []struct {
+		expr       string
+		wantStrict bool
+	}{
+		{"area_ha > 0.5", true},
+		{"area_ha >= 0.5", false},
+	}
+	for _, c := range cases {
+		f, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.expr, err)
+		}
+		if f.MinAreaHa != 0.5 {
+			t.Errorf("Parse(%q).MinAreaHa = %v, want 0.5", c.expr, f.MinAreaHa)
+		}
+		if f.MinAreaStrict != c.wantStrict {
+			t.Errorf("Parse(%q).MinAreaStrict = %v, want %v", c.expr, f.MinAreaStrict, c.wantStrict)
+		}
+	}
+}
+
+func TestParse_RejectsDisallowedColumn(t *testing.T) {
+	if _, err := Parse(`owner_name in ("Acme")`); err == nil {
+		t.Error("Parse should reject a column not in allowedColumns")
+	}
+}
+
+func TestParse_RejectsOperatorColumnMismatch(t *testing.T) {
+	cases := []string{
+		`essence1 > 0.5`,
+		`area_ha in ("1")`,
+		`departement ~ "38"`,
+	}
+	for _, expr := range cases {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should fail: operator not valid for that column", expr)
+		}
+	}
+}
+
+func TestCompile_MultiClause(t *testing.T) {
+	f, err := Parse(`essence1 in ("CHQ","HEQ") and code_tfv ~ "FF1" and departement in ("38") and area_ha > 0.5`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	sql, args := f.Compile(2)
+	wantSQL := " AND fp.essence1 IN ($3,$4) AND (fp.code_tfv LIKE $5) AND fp.departement IN ($6) AND " +
+		"ST_Area(ST_Transform(fp.geom, 2154)) / 10000.0 > $7"
+	if sql != wantSQL {
+		t.Errorf("Compile SQL = %q, want %q", sql, wantSQL)
+	}
+
+	wantArgs := []any{"CHQ", "HEQ", "FF1%", "38", 0.5}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("Compile args = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestCompile_Empty(t *testing.T) {
+	var f *Filter
+	sql, args := f.Compile(0)
+	if sql != "" || args != nil {
+		t.Errorf("Compile on empty filter = (%q, %v), want (\"\", nil)", sql, args)
+	}
+}
+
+func TestHash_DiffersOnStrictness(t *testing.T) {
+	strict, err := Parse("area_ha > 0.5")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	nonStrict, err := Parse("area_ha >= 0.5")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if strict.Hash() == nonStrict.Hash() {
+		t.Error("Hash should differ between a strict and non-strict area_ha filter over the same threshold")
+	}
+}
@@ -4,25 +4,56 @@ import (
 	"context"
 	"fmt"
 
+	"forest-bd-viewer/internal/auth"
+	"forest-bd-viewer/internal/geo/filter"
+
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Queries holds the database pool for spatial queries.
 type Queries struct {
 	DB *pgxpool.Pool
+
+	// RoleMapping and GuestRole configure withSessionUser's JWT-claim →
+	// Postgres-role switch. GuestRole is used when a request carries no
+	// (or an unmapped) JWT role, and must itself be a valid, presumably
+	// low-privilege, Postgres role for RLS policies to fall back to.
+	RoleMapping auth.RoleMapping
+	GuestRole   string
 }
 
-// ForestTile returns a Mapbox Vector Tile (MVT) protobuf for the given tile coordinates.
-// Returns nil bytes (and no error) when the tile contains no forest features.
-func (q *Queries) ForestTile(ctx context.Context, z, x, y int) ([]byte, error) {
+// withSessionUser runs fn inside a transaction whose Postgres role and
+// app.user_id are set from the request's JWT claims (see
+// auth.RunAsSessionUser), so row-level security policies on
+// forest_parcels, cadastre_parcelles, and the admin tables apply per caller
+// rather than to the single shared pool user.
+func (q *Queries) withSessionUser(ctx context.Context, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	return auth.RunAsSessionUser(ctx, q.DB, q.RoleMapping, q.GuestRole, fn)
+}
+
+// ForestTile returns a Mapbox Vector Tile (MVT) protobuf for the given tile
+// coordinates. Returns nil bytes (and no error) when the tile contains no
+// forest features.
+//
+// f, when non-nil and non-empty, restricts the tile to parcels matching the
+// given essences/TFV codes/departements/minimum area (see geo/filter).
+// Callers must fold f.Hash() into their tile cache key, since two different
+// filters over the same z/x/y produce different tiles.
+func (q *Queries) ForestTile(ctx context.Context, z, x, y int, f *filter.Filter) ([]byte, error) {
 	if z < 0 || z > 22 || x < 0 || y < 0 {
 		return nil, fmt.Errorf("invalid tile coordinates: z=%d x=%d y=%d", z, x, y)
 	}
 
+	// filterSQL's placeholders continue from $4 (z, x, y occupy $1-$3, each
+	// referenced twice below).
+	filterSQL, filterArgs := f.Compile(3)
+	args := append([]any{z, x, y}, filterArgs...)
+
 	// ST_TileEnvelope returns the tile bounding box in EPSG:3857 (Web Mercator).
 	// We transform the stored 4326 geometries to 3857 for ST_AsMVTGeom,
 	// and use the inverse transform for the spatial filter (&&).
-	const query = `
+	query := `
 		SELECT ST_AsMVT(q, 'forest', 4096, 'geom')
 		FROM (
 			SELECT
@@ -38,14 +69,17 @@ func (q *Queries) ForestTile(ctx context.Context, z, x, y int) ([]byte, error) {
 					256,
 					true
 				) AS geom
-			FROM forest_parcels
+			FROM forest_parcels fp
 			WHERE geom && ST_Transform(ST_TileEnvelope($1, $2, $3), 4326)
+				` + filterSQL + `
 		) q
 		WHERE geom IS NOT NULL
 	`
 
 	var tile []byte
-	err := q.DB.QueryRow(ctx, query, z, x, y).Scan(&tile)
+	err := q.withSessionUser(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, args...).Scan(&tile)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("forest tile query failed (z=%d x=%d y=%d): %w", z, x, y, err)
 	}
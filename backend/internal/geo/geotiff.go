@@ -3,21 +3,30 @@ package geo
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
+	"net/http"
+	"strconv"
+
+	"forest-bd-viewer/internal/geo/tiff"
 )
 
 // Raster holds a 2D grid of float32 elevation values parsed from a GeoTIFF.
+// Samples are always converted to float32 regardless of the file's on-disk
+// type; SourceDType records what that type was ("uint8", "int16", "float32",
+// etc.) for callers that care, e.g. to pick a sensible color ramp.
 type Raster struct {
 	Width, Height int
 	Data          []float32
 	NoData        float32
 	HasNoData     bool
 	// BBox in the native CRS: [xmin, ymin, xmax, ymax]
-	BBox [4]float64
-	EPSG int
+	BBox        [4]float64
+	EPSG        int
+	SourceDType string
 }
 
 // TIFF tag IDs we care about
@@ -30,6 +39,7 @@ const (
 	tagSamplesPerPixel = 277
 	tagRowsPerStrip    = 278
 	tagStripByteCounts = 279
+	tagPredictor       = 317
 	tagTileWidth       = 322
 	tagTileLength      = 323
 	tagTileOffsets     = 324
@@ -41,368 +51,518 @@ const (
 	tagGDALNoData      = 42113
 )
 
-// TIFF data types
-const (
-	tiffByte   = 1
-	tiffASCII  = 2
-	tiffShort  = 3
-	tiffLong   = 4
-	tiffFloat  = 11
-	tiffDouble = 12
-)
-
-// ParseGeoTIFF reads a float32 GeoTIFF from raw bytes and returns a Raster.
-// Supports uncompressed and DEFLATE-compressed strip/tile-organized TIFFs.
+// ParseGeoTIFF reads a GeoTIFF from raw bytes and returns a Raster, reading
+// band 0 of uint8/uint16/int16/uint32/int32/float32/float64 single- or
+// multi-band rasters. Supports uncompressed, DEFLATE-, LZW-, and PackBits-
+// compressed strip/tile-organized TIFFs, in both classic (magic 42, 32-bit
+// offsets) and BigTIFF (magic 43, 64-bit offsets) flavors — the latter
+// needed for DEMs over the classic format's 4 GiB ceiling. It's a thin
+// wrapper over ParseGeoTIFFReaderAt for callers that already have the whole
+// file in memory.
 func ParseGeoTIFF(data []byte) (*Raster, error) {
-	if len(data) < 8 {
+	return ParseGeoTIFFReaderAt(bytes.NewReader(data), int64(len(data)))
+}
+
+// ParseGeoTIFFBand is ParseGeoTIFF for a specific band of a multi-band
+// (SamplesPerPixel > 1) GeoTIFF, e.g. a multispectral orthoimagery COG.
+// band is 0-indexed; it's an error if band >= the file's SamplesPerPixel.
+func ParseGeoTIFFBand(data []byte, band int) (*Raster, error) {
+	tr := tiff.NewReader(bytes.NewReader(data), int64(len(data)))
+	header, err := tiff.ReadHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	ifd, err := tiff.ParseIFD(tr, header.ByteOrder, header.IFDOffset, header.Big)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readGeoTIFFMeta(ifd)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRaster(tr, meta, nil, band)
+}
+
+// ParseGeoTIFFReaderAt parses a GeoTIFF from an io.ReaderAt without
+// requiring the whole file to be resident in memory — reads are served
+// through a small LRU-cached tiff.Reader, so this works equally well over a
+// local *os.File or an HTTP range-request-backed reader (see
+// ParseGeoTIFFHTTP).
+func ParseGeoTIFFReaderAt(r io.ReaderAt, size int64) (*Raster, error) {
+	if size < 8 {
 		return nil, fmt.Errorf("geotiff: data too short")
 	}
+	tr := tiff.NewReader(r, size)
 
-	// Byte order
-	var bo binary.ByteOrder
-	switch string(data[:2]) {
-	case "II":
-		bo = binary.LittleEndian
-	case "MM":
-		bo = binary.BigEndian
-	default:
-		return nil, fmt.Errorf("geotiff: invalid byte order marker")
+	header, err := tiff.ReadHeader(tr)
+	if err != nil {
+		return nil, err
 	}
+	ifd, err := tiff.ParseIFD(tr, header.ByteOrder, header.IFDOffset, header.Big)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readGeoTIFFMeta(ifd)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRaster(tr, meta, nil, 0)
+}
 
-	magic := bo.Uint16(data[2:4])
-	if magic != 42 {
-		return nil, fmt.Errorf("geotiff: not a TIFF file (magic=%d)", magic)
+// ParseGeoTIFFHTTP parses a Cloud-Optimized GeoTIFF over HTTP, fetching only
+// the bytes it needs via Range requests: the header and IFD, then the
+// tile(s) that intersect bbox (native-CRS [xmin, ymin, xmax, ymax]). Tiles
+// outside bbox are left as NoData, so a multi-GB COG can be read without
+// downloading it in full. Only tile-organized (the COG convention) images
+// are supported.
+func ParseGeoTIFFHTTP(url string, bbox [4]float64) (*Raster, error) {
+	client := http.DefaultClient
+	size, err := probeHTTPSize(url, client)
+	if err != nil {
+		return nil, fmt.Errorf("geotiff: probing %s: %w", url, err)
 	}
 
-	ifdOffset := bo.Uint32(data[4:8])
-	return parseIFD(data, bo, ifdOffset)
+	tr := tiff.NewReader(&httpRangeReaderAt{url: url, client: client}, size)
+
+	header, err := tiff.ReadHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	ifd, err := tiff.ParseIFD(tr, header.ByteOrder, header.IFDOffset, header.Big)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readGeoTIFFMeta(ifd)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.isTiled {
+		return nil, fmt.Errorf("geotiff: %s is not tile-organized, bbox range fetching requires a COG", url)
+	}
+	return decodeRaster(tr, meta, &bbox, 0)
 }
 
-type ifdEntry struct {
-	tag    uint16
-	dtype  uint16
-	count  uint32
-	valOff uint32
+// httpRangeReaderAt adapts an HTTP server supporting Range requests to
+// io.ReaderAt, so ParseGeoTIFFHTTP can reuse the same tiff.Reader/ParseIFD
+// machinery as the local-file path.
+type httpRangeReaderAt struct {
+	url    string
+	client *http.Client
 }
 
-func parseIFD(data []byte, bo binary.ByteOrder, offset uint32) (*Raster, error) {
-	if int(offset)+2 > len(data) {
-		return nil, fmt.Errorf("geotiff: IFD offset out of range")
+func (h *httpRangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, h.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	numEntries := int(bo.Uint16(data[offset:]))
-	entries := make([]ifdEntry, numEntries)
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("range request to %s: unexpected status %s", h.url, resp.Status)
+	}
+	return io.ReadFull(resp.Body, p)
+}
 
-	pos := int(offset) + 2
-	for i := 0; i < numEntries; i++ {
-		if pos+12 > len(data) {
-			return nil, fmt.Errorf("geotiff: truncated IFD entry")
+// probeHTTPSize issues a HEAD request to learn the remote file's size, which
+// tiff.Reader needs up front to bound its reads.
+func probeHTTPSize(url string, client *http.Client) (int64, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength <= 0 {
+		return 0, fmt.Errorf("server did not report a content length for %s", url)
+	}
+	return resp.ContentLength, nil
+}
+
+// geotiffMeta is everything ParseGeoTIFF's IFD walk learns about a raster
+// before decoding any pixel data — decodeRaster uses it to either decode the
+// whole image or, for a bbox-constrained COG read, only the tiles it needs.
+type geotiffMeta struct {
+	width, height   int
+	compression     uint32
+	predictor       uint32
+	samplesPerPixel int
+	dtype           string // "uint8", "int16", "float32", ... — see sourceDType
+	bytesPerSample  int
+
+	isTiled                     bool
+	tileWidth, tileHeight       int
+	tileOffsets, tileByteCounts []uint64
+
+	rowsPerStrip                  int
+	stripOffsets, stripByteCounts []uint64
+
+	noData    float32
+	hasNoData bool
+
+	bbox [4]float64
+	epsg int
+
+	hasGeoTransform  bool
+	originX, originY float64 // xMin, yMax
+	scaleX, scaleY   float64
+
+	bo binary.ByteOrder
+}
+
+// readGeoTIFFMeta interprets a parsed IFD's tags into GeoTIFF-domain
+// metadata — this is the thin consumer side of the generic internal/geo/tiff
+// package, which only knows about IFDs and tags, not strips, tiles,
+// predictors, or GeoKeys.
+func readGeoTIFFMeta(ifd *tiff.IFD) (*geotiffMeta, error) {
+	getUint := func(id uint16, def uint64) (uint64, error) {
+		if !ifd.Has(id) {
+			return def, nil
 		}
-		e := ifdEntry{
-			tag:    bo.Uint16(data[pos:]),
-			dtype:  bo.Uint16(data[pos+2:]),
-			count:  bo.Uint32(data[pos+4:]),
-			valOff: bo.Uint32(data[pos+8:]),
+		vals, err := ifd.Int(id)
+		if err != nil || len(vals) == 0 {
+			return def, err
 		}
-		entries[i] = e
-		pos += 12
+		return vals[0], nil
 	}
 
-	getEntry := func(tag uint16) *ifdEntry {
-		for i := range entries {
-			if entries[i].tag == tag {
-				return &entries[i]
-			}
-		}
-		return nil
+	width64, err := getUint(tagImageWidth, 0)
+	if err != nil {
+		return nil, err
+	}
+	height64, err := getUint(tagImageLength, 0)
+	if err != nil {
+		return nil, err
+	}
+	compression64, err := getUint(tagCompression, 0)
+	if err != nil {
+		return nil, err
+	}
+	bitsPerSample, err := getUint(tagBitsPerSample, 0)
+	if err != nil {
+		return nil, err
+	}
+	sampleFormat, err := getUint(tagSampleFormat, 1) // default unsigned int
+	if err != nil {
+		return nil, err
+	}
+	predictor64, err := getUint(tagPredictor, 1) // default: no predictor
+	if err != nil {
+		return nil, err
+	}
+	samplesPerPixel64, err := getUint(tagSamplesPerPixel, 1)
+	if err != nil {
+		return nil, err
 	}
 
-	getUint32 := func(e *ifdEntry) uint32 {
-		if e == nil {
-			return 0
-		}
-		if e.dtype == tiffShort {
-			return uint32(bo.Uint16(data[int(e.valOff):]))
+	width, height := int(width64), int(height64)
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("geotiff: zero image dimensions")
+	}
+	dtype, err := sourceDType(bitsPerSample, sampleFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &geotiffMeta{
+		width:           width,
+		height:          height,
+		compression:     uint32(compression64),
+		predictor:       uint32(predictor64),
+		samplesPerPixel: int(samplesPerPixel64),
+		dtype:           dtype,
+		bytesPerSample:  int(bitsPerSample) / 8,
+		bo:              ifd.ByteOrder,
+	}
+
+	if ifd.Has(tagGDALNoData) {
+		s, err := ifd.ASCII(tagGDALNoData)
+		if err != nil {
+			return nil, err
 		}
-		// For types that fit in 4 bytes, value is stored in valOff directly
-		if typeSize(e.dtype)*int(e.count) <= 4 {
-			if e.dtype == tiffShort {
-				// value in the valOff field bytes
-				buf := make([]byte, 4)
-				bo.PutUint32(buf, e.valOff)
-				return uint32(bo.Uint16(buf))
+		if s != "" {
+			var parsed bool
+			switch sampleFormat {
+			case 1: // unsigned integer
+				if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+					meta.noData, parsed = float32(v), true
+				}
+			case 2: // signed integer
+				if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+					meta.noData, parsed = float32(v), true
+				}
+			default: // float
+				if v, err := strconv.ParseFloat(s, 64); err == nil {
+					meta.noData, parsed = float32(v), true
+				}
 			}
-			return e.valOff
+			meta.hasNoData = parsed
 		}
-		return e.valOff
 	}
 
-	getUint32Value := func(tag uint16) uint32 {
-		e := getEntry(tag)
-		if e == nil {
-			return 0
+	if ifd.Has(tagTileWidth) {
+		meta.isTiled = true
+		tw, err := getUint(tagTileWidth, 0)
+		if err != nil {
+			return nil, err
 		}
-		// If data fits in 4 bytes, it's stored inline in valOff
-		sz := typeSize(e.dtype) * int(e.count)
-		if sz <= 4 {
-			if e.dtype == tiffShort && e.count == 1 {
-				buf := make([]byte, 4)
-				bo.PutUint32(buf, e.valOff)
-				return uint32(bo.Uint16(buf))
-			}
-			return e.valOff
-		}
-		// Otherwise valOff is an offset into the file
-		off := e.valOff
-		if e.dtype == tiffLong {
-			return bo.Uint32(data[off:])
-		}
-		if e.dtype == tiffShort {
-			return uint32(bo.Uint16(data[off:]))
-		}
-		return e.valOff
-	}
-	_ = getUint32
-
-	readUint32Array := func(e *ifdEntry) []uint32 {
-		if e == nil {
-			return nil
-		}
-		n := int(e.count)
-		arr := make([]uint32, n)
-		sz := typeSize(e.dtype) * n
-		var src []byte
-		if sz <= 4 {
-			buf := make([]byte, 4)
-			bo.PutUint32(buf, e.valOff)
-			src = buf
-		} else {
-			off := int(e.valOff)
-			if off+sz > len(data) {
-				return nil
-			}
-			src = data[off:]
+		th, err := getUint(tagTileLength, 0)
+		if err != nil {
+			return nil, err
 		}
-		for i := 0; i < n; i++ {
-			if e.dtype == tiffShort {
-				arr[i] = uint32(bo.Uint16(src[i*2:]))
-			} else {
-				arr[i] = bo.Uint32(src[i*4:])
-			}
+		meta.tileWidth, meta.tileHeight = int(tw), int(th)
+		meta.tileOffsets, err = ifd.Int(tagTileOffsets)
+		if err != nil {
+			return nil, err
 		}
-		return arr
-	}
-
-	readFloat64Array := func(e *ifdEntry) []float64 {
-		if e == nil {
-			return nil
+		meta.tileByteCounts, err = ifd.Int(tagTileByteCounts)
+		if err != nil {
+			return nil, err
 		}
-		n := int(e.count)
-		off := int(e.valOff)
-		if off+n*8 > len(data) {
-			return nil
+		if len(meta.tileOffsets) == 0 {
+			return nil, fmt.Errorf("geotiff: no tile offsets")
 		}
-		arr := make([]float64, n)
-		for i := 0; i < n; i++ {
-			arr[i] = math.Float64frombits(bo.Uint64(data[off+i*8:]))
+	} else {
+		rowsPerStrip, err := getUint(tagRowsPerStrip, uint64(height))
+		if err != nil {
+			return nil, err
+		}
+		meta.rowsPerStrip = int(rowsPerStrip)
+		meta.stripOffsets, err = ifd.Int(tagStripOffsets)
+		if err != nil {
+			return nil, err
+		}
+		meta.stripByteCounts, err = ifd.Int(tagStripByteCounts)
+		if err != nil {
+			return nil, err
+		}
+		if len(meta.stripOffsets) == 0 {
+			return nil, fmt.Errorf("geotiff: no strip offsets")
 		}
-		return arr
-	}
-
-	width := int(getUint32Value(tagImageWidth))
-	height := int(getUint32Value(tagImageLength))
-	compression := getUint32Value(tagCompression)
-	bitsPerSample := getUint32Value(tagBitsPerSample)
-	sampleFormat := getUint32Value(tagSampleFormat)
-	if sampleFormat == 0 {
-		sampleFormat = 1 // default unsigned int
 	}
 
-	if width == 0 || height == 0 {
-		return nil, fmt.Errorf("geotiff: zero image dimensions")
-	}
-	if bitsPerSample != 32 {
-		return nil, fmt.Errorf("geotiff: expected 32 bits/sample, got %d", bitsPerSample)
-	}
-	if sampleFormat != 3 {
-		return nil, fmt.Errorf("geotiff: expected float sample format (3), got %d", sampleFormat)
+	// ModelPixelScaleTag + ModelTiepointTag → geotransform + BBox
+	if ifd.Has(tagModelPixelScale) && ifd.Has(tagModelTiepoint) {
+		scales, err := ifd.Float(tagModelPixelScale)
+		if err != nil {
+			return nil, err
+		}
+		tiepoints, err := ifd.Float(tagModelTiepoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(scales) >= 2 && len(tiepoints) >= 6 {
+			meta.scaleX, meta.scaleY = scales[0], scales[1]
+			tieI, tieJ := tiepoints[0], tiepoints[1]
+			tieX, tieY := tiepoints[3], tiepoints[4]
+
+			meta.originX = tieX - tieI*meta.scaleX
+			meta.originY = tieY + tieJ*meta.scaleY
+			meta.hasGeoTransform = true
+
+			xMax := meta.originX + float64(width)*meta.scaleX
+			yMin := meta.originY - float64(height)*meta.scaleY
+			meta.bbox = [4]float64{meta.originX, yMin, xMax, meta.originY}
+		}
 	}
 
-	// Read NoData
-	var noData float32
-	var hasNoData bool
-	if e := getEntry(tagGDALNoData); e != nil {
-		off := int(e.valOff)
-		end := off
-		for end < len(data) && data[end] != 0 {
-			end++
+	// GeoKeyDirectory → EPSG
+	if ifd.Has(tagGeoKeyDirectory) {
+		keys, err := ifd.Int(tagGeoKeyDirectory)
+		if err != nil {
+			return nil, err
 		}
-		if end > off {
-			s := string(data[off:end])
-			var f float64
-			if _, err := fmt.Sscanf(s, "%f", &f); err == nil {
-				noData = float32(f)
-				hasNoData = true
+		// GeoKeyDirectory: [keyDirVersion, keyRevision, minorRevision, numberOfKeys, ...]
+		// Then groups of 4: [keyID, TIFFTagLocation, count, valueOffset]
+		if len(keys) > 4 {
+			nKeys := int(keys[3])
+			for k := 0; k < nKeys && 4+k*4+3 < len(keys); k++ {
+				keyID := keys[4+k*4]
+				loc := keys[4+k*4+1]
+				val := keys[4+k*4+3]
+				// ProjectedCSTypeGeoKey = 3072
+				if keyID == 3072 && loc == 0 {
+					meta.epsg = int(val)
+				}
+				// GeographicTypeGeoKey = 2048 (fallback)
+				if keyID == 2048 && loc == 0 && meta.epsg == 0 {
+					meta.epsg = int(val)
+				}
 			}
 		}
 	}
 
-	// Determine if tiled or stripped
-	tileWidthEntry := getEntry(tagTileWidth)
-	isTiled := tileWidthEntry != nil
+	return meta, nil
+}
 
-	pixels := make([]float32, width*height)
-	// Initialize with nodata
-	if hasNoData {
-		for i := range pixels {
-			pixels[i] = noData
-		}
+// decodeRaster decodes pixel data described by meta into a Raster, reading
+// only the given band (0-indexed) of a multi-band file. If bbox is nil,
+// every tile/strip is decoded. If bbox is non-nil (the ParseGeoTIFFHTTP COG
+// path), only tiles intersecting it are fetched and decoded; everything
+// else is left as NoData, and meta.isTiled must be true.
+func decodeRaster(tr *tiff.Reader, meta *geotiffMeta, bbox *[4]float64, band int) (*Raster, error) {
+	if band < 0 || band >= meta.samplesPerPixel {
+		return nil, fmt.Errorf("geotiff: band %d out of range (file has %d)", band, meta.samplesPerPixel)
 	}
 
-	if isTiled {
-		tw := int(getUint32Value(tagTileWidth))
-		th := int(getUint32Value(tagTileLength))
-		offsets := readUint32Array(getEntry(tagTileOffsets))
-		byteCounts := readUint32Array(getEntry(tagTileByteCounts))
-
-		if len(offsets) == 0 {
-			return nil, fmt.Errorf("geotiff: no tile offsets")
+	pixels := make([]float32, meta.width*meta.height)
+	if meta.hasNoData {
+		for i := range pixels {
+			pixels[i] = meta.noData
 		}
+	}
 
-		tilesX := (width + tw - 1) / tw
-		tilesY := (height + th - 1) / th
+	if meta.isTiled {
+		tw, th := meta.tileWidth, meta.tileHeight
+		tilesX := (meta.width + tw - 1) / tw
+		tilesY := (meta.height + th - 1) / th
 
 		for ty := 0; ty < tilesY; ty++ {
 			for tx := 0; tx < tilesX; tx++ {
 				idx := ty*tilesX + tx
-				if idx >= len(offsets) {
+				if idx >= len(meta.tileOffsets) {
 					break
 				}
-				raw, err := decompressChunk(data, offsets[idx], byteCounts[idx], compression)
+				if bbox != nil && !tileIntersectsBBox(meta, tx, ty, *bbox) {
+					continue
+				}
+				raw, err := decompressChunk(tr, meta.tileOffsets[idx], meta.tileByteCounts[idx], meta.compression)
 				if err != nil {
 					return nil, fmt.Errorf("geotiff: tile (%d,%d): %w", tx, ty, err)
 				}
-				writeTileToPixels(raw, pixels, bo, tx*tw, ty*th, tw, th, width, height)
+				applyPredictor(raw, meta.predictor, tw, meta.samplesPerPixel, th, meta.bytesPerSample, meta.bo)
+				writeTileToPixels(raw, pixels, meta.dtype, meta.bytesPerSample, meta.bo, tx*tw, ty*th, tw, th, meta.width, meta.height, meta.samplesPerPixel, band)
 			}
 		}
 	} else {
-		// Strip-based
-		rowsPerStrip := int(getUint32Value(tagRowsPerStrip))
-		if rowsPerStrip == 0 {
-			rowsPerStrip = height
-		}
-		offsets := readUint32Array(getEntry(tagStripOffsets))
-		byteCounts := readUint32Array(getEntry(tagStripByteCounts))
-
-		if len(offsets) == 0 {
-			return nil, fmt.Errorf("geotiff: no strip offsets")
+		if bbox != nil {
+			return nil, fmt.Errorf("geotiff: bbox-constrained decode requires a tiled (COG) image")
 		}
-
+		stride := meta.samplesPerPixel * meta.bytesPerSample
 		y := 0
-		for i, off := range offsets {
-			bc := uint32(0)
-			if i < len(byteCounts) {
-				bc = byteCounts[i]
+		for i, off := range meta.stripOffsets {
+			bc := uint64(0)
+			if i < len(meta.stripByteCounts) {
+				bc = meta.stripByteCounts[i]
 			}
-			raw, err := decompressChunk(data, off, bc, compression)
+			raw, err := decompressChunk(tr, off, bc, meta.compression)
 			if err != nil {
 				return nil, fmt.Errorf("geotiff: strip %d: %w", i, err)
 			}
-			rows := rowsPerStrip
-			if y+rows > height {
-				rows = height - y
+			rows := meta.rowsPerStrip
+			if y+rows > meta.height {
+				rows = meta.height - y
 			}
-			n := rows * width
-			if len(raw) < n*4 {
-				n = len(raw) / 4
+			applyPredictor(raw, meta.predictor, meta.width, meta.samplesPerPixel, rows, meta.bytesPerSample, meta.bo)
+			n := rows * meta.width
+			if len(raw) < n*stride {
+				n = len(raw) / stride
 			}
 			for j := 0; j < n; j++ {
-				pixels[y*width+j] = math.Float32frombits(bo.Uint32(raw[j*4:]))
+				sampleOff := j*stride + band*meta.bytesPerSample
+				pixels[y*meta.width+j] = readSample(raw[sampleOff:], meta.dtype, meta.bo)
 			}
 			y += rows
 		}
 	}
 
-	// Parse geo-referencing
-	r := &Raster{
-		Width:     width,
-		Height:    height,
-		Data:      pixels,
-		NoData:    noData,
-		HasNoData: hasNoData,
-	}
-
-	// ModelPixelScaleTag + ModelTiepointTag → BBox
-	scales := readFloat64Array(getEntry(tagModelPixelScale))
-	tiepoints := readFloat64Array(getEntry(tagModelTiepoint))
-	if len(scales) >= 2 && len(tiepoints) >= 6 {
-		scaleX := scales[0]
-		scaleY := scales[1]
-		tieI := tiepoints[0]
-		tieJ := tiepoints[1]
-		tieX := tiepoints[3]
-		tieY := tiepoints[4]
-
-		xMin := tieX - tieI*scaleX
-		yMax := tieY + tieJ*scaleY
-		xMax := xMin + float64(width)*scaleX
-		yMin := yMax - float64(height)*scaleY
+	return &Raster{
+		Width:       meta.width,
+		Height:      meta.height,
+		Data:        pixels,
+		NoData:      meta.noData,
+		HasNoData:   meta.hasNoData,
+		BBox:        meta.bbox,
+		EPSG:        meta.epsg,
+		SourceDType: meta.dtype,
+	}, nil
+}
 
-		r.BBox = [4]float64{xMin, yMin, xMax, yMax}
+// tileIntersectsBBox reports whether tile (tx, ty)'s footprint overlaps
+// bbox (native-CRS [xmin, ymin, xmax, ymax]). Without a geotransform we
+// can't cull anything, so every tile is considered intersecting.
+func tileIntersectsBBox(meta *geotiffMeta, tx, ty int, bbox [4]float64) bool {
+	if !meta.hasGeoTransform {
+		return true
 	}
+	xMin := meta.originX + float64(tx*meta.tileWidth)*meta.scaleX
+	xMax := xMin + float64(meta.tileWidth)*meta.scaleX
+	yMax := meta.originY - float64(ty*meta.tileHeight)*meta.scaleY
+	yMin := yMax - float64(meta.tileHeight)*meta.scaleY
+	return xMin < bbox[2] && xMax > bbox[0] && yMin < bbox[3] && yMax > bbox[1]
+}
 
-	// Try to extract EPSG from GeoKeyDirectory
-	if e := getEntry(tagGeoKeyDirectory); e != nil {
-		keys := readUint32Array(e)
-		// GeoKeyDirectory: [keyDirVersion, keyRevision, minorRevision, numberOfKeys, ...]
-		// Then groups of 4: [keyID, TIFFTagLocation, count, valueOffset]
-		if len(keys) > 4 {
-			nKeys := int(keys[3])
-			for k := 0; k < nKeys && 4+k*4+3 < len(keys); k++ {
-				keyID := keys[4+k*4]
-				loc := keys[4+k*4+1]
-				val := keys[4+k*4+3]
-				// ProjectedCSTypeGeoKey = 3072
-				if keyID == 3072 && loc == 0 {
-					r.EPSG = int(val)
-				}
-				// GeographicTypeGeoKey = 2048 (fallback)
-				if keyID == 2048 && loc == 0 && r.EPSG == 0 {
-					r.EPSG = int(val)
-				}
-			}
-		}
+// sourceDType maps a TIFF (BitsPerSample, SampleFormat) pair to the name of
+// the pixel type it describes, the same vocabulary GDAL uses for its band
+// data types. SampleFormat 1 is unsigned integer, 2 signed integer, 3 IEEE
+// float (TIFF defaults SampleFormat to 1 when the tag is absent).
+func sourceDType(bitsPerSample, sampleFormat uint64) (string, error) {
+	switch {
+	case bitsPerSample == 8 && sampleFormat == 1:
+		return "uint8", nil
+	case bitsPerSample == 8 && sampleFormat == 2:
+		return "int8", nil
+	case bitsPerSample == 16 && sampleFormat == 1:
+		return "uint16", nil
+	case bitsPerSample == 16 && sampleFormat == 2:
+		return "int16", nil
+	case bitsPerSample == 32 && sampleFormat == 1:
+		return "uint32", nil
+	case bitsPerSample == 32 && sampleFormat == 2:
+		return "int32", nil
+	case bitsPerSample == 32 && sampleFormat == 3:
+		return "float32", nil
+	case bitsPerSample == 64 && sampleFormat == 3:
+		return "float64", nil
+	default:
+		return "", fmt.Errorf("geotiff: unsupported sample format (bits=%d format=%d)", bitsPerSample, sampleFormat)
 	}
-
-	return r, nil
 }
 
-func typeSize(dtype uint16) int {
+// readSample decodes one sample of the given dtype (see sourceDType) from
+// the front of src, converting it to float32.
+func readSample(src []byte, dtype string, bo binary.ByteOrder) float32 {
 	switch dtype {
-	case tiffByte, tiffASCII:
-		return 1
-	case tiffShort:
-		return 2
-	case tiffLong, tiffFloat:
-		return 4
-	case tiffDouble:
-		return 8
+	case "uint8":
+		return float32(src[0])
+	case "int8":
+		return float32(int8(src[0]))
+	case "uint16":
+		return float32(bo.Uint16(src))
+	case "int16":
+		return float32(int16(bo.Uint16(src)))
+	case "uint32":
+		return float32(bo.Uint32(src))
+	case "int32":
+		return float32(int32(bo.Uint32(src)))
+	case "float32":
+		return math.Float32frombits(bo.Uint32(src))
+	case "float64":
+		return float32(math.Float64frombits(bo.Uint64(src)))
 	default:
-		return 1
+		return 0
 	}
 }
 
-func decompressChunk(data []byte, offset, byteCount, compression uint32) ([]byte, error) {
-	off := int(offset)
-	bc := int(byteCount)
-	if off+bc > len(data) {
-		return nil, fmt.Errorf("chunk out of bounds (off=%d bc=%d len=%d)", off, bc, len(data))
+func decompressChunk(tr *tiff.Reader, offset, byteCount uint64, compression uint32) ([]byte, error) {
+	chunk, err := tr.ReadAt(offset, int(byteCount))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk (off=%d bc=%d): %w", offset, byteCount, err)
 	}
-	chunk := data[off : off+bc]
 
 	switch compression {
 	case 1: // None
 		return chunk, nil
+	case 5: // LZW
+		return decompressLZW(chunk)
 	case 8, 32946: // DEFLATE / new-style DEFLATE
 		r, err := zlib.NewReader(bytes.NewReader(chunk))
 		if err != nil {
@@ -410,12 +570,229 @@ func decompressChunk(data []byte, offset, byteCount, compression uint32) ([]byte
 		}
 		defer r.Close()
 		return io.ReadAll(r)
+	case 32773: // PackBits
+		return decompressPackBits(chunk)
 	default:
 		return nil, fmt.Errorf("unsupported compression type %d", compression)
 	}
 }
 
-func writeTileToPixels(raw []byte, pixels []float32, bo binary.ByteOrder, startX, startY, tw, th, imgW, imgH int) {
+// lzwBitReader reads MSB-first, variable-width (9-12 bit) codes from a TIFF
+// LZW stream.
+type lzwBitReader struct {
+	data []byte
+	pos  int // bit offset
+}
+
+// readBits reads the next n bits as a single value, MSB-first. ok is false
+// once the stream is exhausted.
+func (r *lzwBitReader) readBits(n int) (code int, ok bool) {
+	for i := 0; i < n; i++ {
+		byteIdx := r.pos / 8
+		if byteIdx >= len(r.data) {
+			return 0, false
+		}
+		bit := (r.data[byteIdx] >> (7 - uint(r.pos%8))) & 1
+		code = (code << 1) | int(bit)
+		r.pos++
+	}
+	return code, true
+}
+
+// decompressLZW decodes a TIFF-flavored LZW stream (predictor-agnostic; any
+// horizontal differencing predictor is undone separately). TIFF's LZW
+// differs from the classic Welch/GIF variant in one detail: the code width
+// grows one code earlier, i.e. when the next code to be assigned would be
+// 2^width-1 rather than 2^width.
+func decompressLZW(chunk []byte) ([]byte, error) {
+	const (
+		clearCode    = 256
+		eoiCode      = 257
+		minCodeWidth = 9
+		maxCodeWidth = 12
+	)
+
+	newDict := func() [][]byte {
+		dict := make([][]byte, 258, 4096)
+		for i := 0; i < 256; i++ {
+			dict[i] = []byte{byte(i)}
+		}
+		return dict
+	}
+
+	var out bytes.Buffer
+	dict := newDict()
+	codeWidth := minCodeWidth
+	var prev []byte
+
+	br := &lzwBitReader{data: chunk}
+	for {
+		code, ok := br.readBits(codeWidth)
+		if !ok {
+			break
+		}
+		if code == clearCode {
+			dict = newDict()
+			codeWidth = minCodeWidth
+			prev = nil
+			continue
+		}
+		if code == eoiCode {
+			break
+		}
+
+		var entry []byte
+		switch {
+		case code < len(dict):
+			entry = dict[code]
+		case code == len(dict) && prev != nil:
+			entry = append(append([]byte{}, prev...), prev[0])
+		default:
+			return nil, fmt.Errorf("lzw: invalid code %d", code)
+		}
+		out.Write(entry)
+
+		if prev != nil {
+			dict = append(dict, append(append([]byte{}, prev...), entry[0]))
+			if len(dict) == (1<<uint(codeWidth))-1 && codeWidth < maxCodeWidth {
+				codeWidth++
+			}
+		}
+		prev = entry
+	}
+	return out.Bytes(), nil
+}
+
+// decompressPackBits decodes Apple PackBits byte-run RLE: a header byte n
+// followed by either n+1 literal bytes (0 <= n <= 127), a single byte
+// repeated 1-n times (-127 <= n <= -1), or nothing (n == -128, a no-op
+// padding byte some encoders emit).
+func decompressPackBits(chunk []byte) ([]byte, error) {
+	var out bytes.Buffer
+	i := 0
+	for i < len(chunk) {
+		n := int8(chunk[i])
+		i++
+		switch {
+		case n >= 0:
+			count := int(n) + 1
+			if i+count > len(chunk) {
+				return nil, fmt.Errorf("packbits: literal run out of bounds")
+			}
+			out.Write(chunk[i : i+count])
+			i += count
+		case n != -128:
+			if i >= len(chunk) {
+				return nil, fmt.Errorf("packbits: repeat run out of bounds")
+			}
+			count := 1 - int(n)
+			b := chunk[i]
+			i++
+			for k := 0; k < count; k++ {
+				out.WriteByte(b)
+			}
+		default: // n == -128: no-op
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// applyPredictor undoes the horizontal differencing predictor (tag 317) a
+// LZW- or DEFLATE-compressed GeoTIFF may have applied before compression, in
+// place on raw. rowWidth is the tile/image width in pixels, rows the number
+// of complete rows raw holds (a tile's full height, or a strip's row
+// count), and bps the sample's width in bytes (1, 2, 4, or 8). Predictor 1
+// (the default, no predictor) is a no-op.
+func applyPredictor(raw []byte, predictor uint32, rowWidth, samplesPerPixel, rows, bps int, bo binary.ByteOrder) {
+	switch predictor {
+	case 2:
+		undoHorizontalPredictor(raw, rowWidth, samplesPerPixel, rows, bps, bo)
+	case 3:
+		undoFloatPredictor(raw, rowWidth, samplesPerPixel, rows, bps, bo)
+	}
+}
+
+// undoHorizontalPredictor reverses Predictor=2: each sample was stored as
+// its delta from the previous sample of the same channel in the row; add
+// the previous sample back, channel by channel, left to right, as a
+// bps-byte word (wrapping addition, same as the encoder's wrapping
+// subtraction).
+func undoHorizontalPredictor(raw []byte, rowWidth, samplesPerPixel, rows, bps int, bo binary.ByteOrder) {
+	rowBytes := rowWidth * samplesPerPixel * bps
+	for row := 0; row < rows; row++ {
+		start := row * rowBytes
+		if start+rowBytes > len(raw) {
+			break
+		}
+		for x := 1; x < rowWidth; x++ {
+			for c := 0; c < samplesPerPixel; c++ {
+				idx := start + (x*samplesPerPixel+c)*bps
+				prevIdx := start + ((x-1)*samplesPerPixel+c)*bps
+				switch bps {
+				case 1:
+					raw[idx] += raw[prevIdx]
+				case 2:
+					bo.PutUint16(raw[idx:], bo.Uint16(raw[idx:])+bo.Uint16(raw[prevIdx:]))
+				case 4:
+					bo.PutUint32(raw[idx:], bo.Uint32(raw[idx:])+bo.Uint32(raw[prevIdx:]))
+				case 8:
+					bo.PutUint64(raw[idx:], bo.Uint64(raw[idx:])+bo.Uint64(raw[prevIdx:]))
+				}
+			}
+		}
+	}
+}
+
+// undoFloatPredictor reverses Predictor=3, the GDAL floating-point
+// predictor (only meaningful for bps == 4 or 8, float32/float64 samples).
+// Each row was encoded by splitting every sample's bps bytes into bps
+// byte-planes (plane 0 the most significant byte of each sample, laid out
+// big-endian-style regardless of the file's actual byte order), then
+// horizontally delta-encoding each plane independently. Decoding reverses
+// that: cumulative-sum each plane back to absolute byte values, then
+// transpose the planes back into each sample's bytes, in the file's actual
+// byte order.
+func undoFloatPredictor(raw []byte, rowWidth, samplesPerPixel, rows, bps int, bo binary.ByteOrder) {
+	rowSamples := rowWidth * samplesPerPixel
+	rowBytes := rowSamples * bps
+	tmp := make([]byte, rowBytes)
+
+	for row := 0; row < rows; row++ {
+		start := row * rowBytes
+		if start+rowBytes > len(raw) {
+			break
+		}
+		rowData := raw[start : start+rowBytes]
+
+		// Step 1: undo the per-plane horizontal differencing (byte-wise,
+		// wrapping addition), each plane a contiguous run of rowSamples
+		// bytes.
+		for p := 0; p < bps; p++ {
+			planeStart := p * rowSamples
+			for i := 1; i < rowSamples; i++ {
+				rowData[planeStart+i] += rowData[planeStart+i-1]
+			}
+		}
+
+		// Step 2: transpose the bps planes back into each sample's
+		// interleaved bytes. Plane 0 is the most significant byte, so for a
+		// little-endian file that lands last in the sample and for a
+		// big-endian file it lands first.
+		copy(tmp, rowData)
+		for i := 0; i < rowSamples; i++ {
+			for p := 0; p < bps; p++ {
+				byteIdx := p
+				if bo == binary.LittleEndian {
+					byteIdx = bps - 1 - p
+				}
+				rowData[i*bps+byteIdx] = tmp[p*rowSamples+i]
+			}
+		}
+	}
+}
+
+func writeTileToPixels(raw []byte, pixels []float32, dtype string, bps int, bo binary.ByteOrder, startX, startY, tw, th, imgW, imgH, samplesPerPixel, band int) {
+	stride := samplesPerPixel * bps
 	for row := 0; row < th; row++ {
 		y := startY + row
 		if y >= imgH {
@@ -426,11 +803,11 @@ func writeTileToPixels(raw []byte, pixels []float32, bo binary.ByteOrder, startX
 			if x >= imgW {
 				continue
 			}
-			idx := row*tw + col
-			if idx*4+4 > len(raw) {
+			sampleOff := (row*tw+col)*stride + band*bps
+			if sampleOff+bps > len(raw) {
 				continue
 			}
-			pixels[y*imgW+x] = math.Float32frombits(bo.Uint32(raw[idx*4:]))
+			pixels[y*imgW+x] = readSample(raw[sampleOff:], dtype, bo)
 		}
 	}
 }
@@ -0,0 +1,131 @@
+package geo
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// bitWriter packs MSB-first variable-width codes into bytes, mirroring the
+// layout lzwBitReader expects, so tests can build TIFF-flavored LZW streams
+// without depending on the stdlib compress/lzw encoder (which doesn't
+// implement TIFF's early code-width change).
+type bitWriter struct {
+	bits []bool
+}
+
+func (w *bitWriter) writeCode(code, width int) {
+	for i := width - 1; i >= 0; i-- {
+		w.bits = append(w.bits, (code>>uint(i))&1 == 1)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	out := make([]byte, (len(w.bits)+7)/8)
+	for i, b := range w.bits {
+		if b {
+			out[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return out
+}
+
+func TestDecompressLZW_LiteralCodes(t *testing.T) {
+	w := &bitWriter{}
+	for _, code := range []int{256, 65, 65, 65, 65, 257} { // clear, A, A, A, A, eoi
+		w.writeCode(code, 9)
+	}
+
+	got, err := decompressLZW(w.bytes())
+	if err != nil {
+		t.Fatalf("decompressLZW: %v", err)
+	}
+	if string(got) != "AAAA" {
+		t.Errorf("decompressLZW = %q, want %q", got, "AAAA")
+	}
+}
+
+func TestDecompressLZW_DictionaryBackReference(t *testing.T) {
+	// clear, 'A', 'B', <AB> (code 258, built after 'B'), eoi — decodes to
+	// "ABAB" by reusing the two-byte dictionary entry the decoder builds
+	// after emitting 'B'.
+	w := &bitWriter{}
+	for _, code := range []int{256, 65, 66, 258, 257} {
+		w.writeCode(code, 9)
+	}
+
+	got, err := decompressLZW(w.bytes())
+	if err != nil {
+		t.Fatalf("decompressLZW: %v", err)
+	}
+	if string(got) != "ABAB" {
+		t.Errorf("decompressLZW = %q, want %q", got, "ABAB")
+	}
+}
+
+func TestDecompressPackBits(t *testing.T) {
+	// Literal run of 3 bytes ("ABC"), then a repeat run of 4 'X's, then the
+	// no-op padding byte some encoders emit.
+	// 0xFD and 0x80 are the header bytes -3 and -128 read as int8.
+	chunk := []byte{2, 'A', 'B', 'C', 0xFD, 'X', 0x80}
+
+	got, err := decompressPackBits(chunk)
+	if err != nil {
+		t.Fatalf("decompressPackBits: %v", err)
+	}
+	if string(got) != "ABCXXXX" {
+		t.Errorf("decompressPackBits = %q, want %q", got, "ABCXXXX")
+	}
+}
+
+func TestUndoHorizontalPredictor(t *testing.T) {
+	original := []uint16{10, 15, 12, 100}
+	encoded := make([]byte, len(original)*2)
+	var prev uint16
+	for i, v := range original {
+		delta := v
+		if i > 0 {
+			delta = v - prev
+		}
+		binary.BigEndian.PutUint16(encoded[i*2:], delta)
+		prev = v
+	}
+
+	undoHorizontalPredictor(encoded, len(original), 1, 1, 2, binary.BigEndian)
+
+	for i, want := range original {
+		if got := binary.BigEndian.Uint16(encoded[i*2:]); got != want {
+			t.Errorf("sample %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestUndoFloatPredictor(t *testing.T) {
+	// Two 4-byte big-endian samples, GDAL-float-predictor-encoded by hand:
+	// each of the 4 byte-planes across the row is horizontally delta-coded
+	// independently before the planes are concatenated.
+	encoded := []byte{
+		0x00, 0x00, // plane 0 (byte 0 of each sample), delta-coded
+		0x01, 0x04, // plane 1
+		0x02, 0x07, // plane 2
+		0x03, 0x0A, // plane 3
+	}
+	want := []byte{
+		0x00, 0x01, 0x02, 0x03, // sample 0
+		0x00, 0x05, 0x09, 0x0D, // sample 1
+	}
+
+	undoFloatPredictor(encoded, 2, 1, 1, 4, binary.BigEndian)
+
+	if string(encoded) != string(want) {
+		t.Errorf("undoFloatPredictor = % X, want % X", encoded, want)
+	}
+}
+
+func TestApplyPredictor_NoopForDefault(t *testing.T) {
+	raw := []byte{1, 2, 3, 4}
+	want := append([]byte(nil), raw...)
+	applyPredictor(raw, 1, 2, 1, 1, 2, binary.BigEndian)
+	if string(raw) != string(want) {
+		t.Errorf("applyPredictor with predictor=1 modified raw: got % X, want % X", raw, want)
+	}
+}
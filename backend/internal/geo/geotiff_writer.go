@@ -0,0 +1,184 @@
+package geo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"forest-bd-viewer/internal/geo/tiff"
+)
+
+// Additional TIFF tags needed only for writing (the reader in geotiff.go
+// doesn't need these).
+const (
+	tagPhotometric = 262
+)
+
+// TIFF field types of the tags written below.
+const (
+	tiffASCII  = tiff.ASCII
+	tiffShort  = tiff.Short
+	tiffLong   = tiff.Long
+	tiffDouble = tiff.Double
+)
+
+// WriteGeoTIFF serializes r to a georeferenced, single-strip, uncompressed
+// float32 GeoTIFF at path — the raw CHM raster a GIS user can load into
+// QGIS/ArcGIS for zonal stats, thresholding, or classification, as opposed
+// to the pre-baked color-ramp PNG generateCHMImage produces.
+//
+// The file carries ModelPixelScaleTag/ModelTiepointTag (from r.BBox) and a
+// GeoKeyDirectoryTag identifying r.EPSG, plus a GDAL_NODATA tag when r has a
+// nodata value, so downstream tools pick up the CRS and nodata mask
+// automatically.
+func WriteGeoTIFF(path string, r *Raster) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("geotiff: creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	bo := binary.LittleEndian
+	pixelDataOffset := uint32(8)
+	pixelDataLen := uint32(r.Width * r.Height * 4)
+
+	var noDataStr string
+	if r.HasNoData {
+		noDataStr = fmt.Sprintf("%g", r.NoData)
+	}
+
+	// GeoKeyDirectory: version 1.1.0, one key (either Projected or
+	// Geographic CS type depending on whether EPSG looks projected).
+	geoKeyID := uint16(3072) // ProjectedCSTypeGeoKey
+	if r.EPSG != 0 && r.EPSG == 4326 {
+		geoKeyID = 2048 // GeographicTypeGeoKey
+	}
+	geoKeys := []uint16{1, 1, 0, 1, geoKeyID, 0, 1, uint16(r.EPSG)}
+
+	type tagValue struct {
+		tag, dtype uint16
+		count      uint32
+		inlineVal  uint32 // used when the value fits in 4 bytes
+		out        []byte // used otherwise; offset is patched in later
+	}
+
+	tags := []tagValue{
+		{tag: tagImageWidth, dtype: tiffLong, count: 1, inlineVal: uint32(r.Width)},
+		{tag: tagImageLength, dtype: tiffLong, count: 1, inlineVal: uint32(r.Height)},
+		{tag: tagBitsPerSample, dtype: tiffShort, count: 1, inlineVal: 32},
+		{tag: tagCompression, dtype: tiffShort, count: 1, inlineVal: 1},
+		{tag: tagPhotometric, dtype: tiffShort, count: 1, inlineVal: 1}, // BlackIsZero
+		{tag: tagStripOffsets, dtype: tiffLong, count: 1, inlineVal: pixelDataOffset},
+		{tag: tagSamplesPerPixel, dtype: tiffShort, count: 1, inlineVal: 1},
+		{tag: tagRowsPerStrip, dtype: tiffLong, count: 1, inlineVal: uint32(r.Height)},
+		{tag: tagStripByteCounts, dtype: tiffLong, count: 1, inlineVal: pixelDataLen},
+		{tag: tagSampleFormat, dtype: tiffShort, count: 1, inlineVal: 3}, // float
+	}
+
+	scaleBytes := make([]byte, 24)
+	scaleX := (r.BBox[2] - r.BBox[0]) / float64(r.Width)
+	scaleY := (r.BBox[3] - r.BBox[1]) / float64(r.Height)
+	bo.PutUint64(scaleBytes[0:], math.Float64bits(scaleX))
+	bo.PutUint64(scaleBytes[8:], math.Float64bits(scaleY))
+	bo.PutUint64(scaleBytes[16:], math.Float64bits(0))
+	tags = append(tags, tagValue{tag: tagModelPixelScale, dtype: tiffDouble, count: 3, out: scaleBytes})
+
+	tiepointBytes := make([]byte, 48)
+	bo.PutUint64(tiepointBytes[0:], math.Float64bits(0))
+	bo.PutUint64(tiepointBytes[8:], math.Float64bits(0))
+	bo.PutUint64(tiepointBytes[16:], math.Float64bits(0))
+	bo.PutUint64(tiepointBytes[24:], math.Float64bits(r.BBox[0]))
+	bo.PutUint64(tiepointBytes[32:], math.Float64bits(r.BBox[3]))
+	bo.PutUint64(tiepointBytes[40:], math.Float64bits(0))
+	tags = append(tags, tagValue{tag: tagModelTiepoint, dtype: tiffDouble, count: 6, out: tiepointBytes})
+
+	geoKeyBytes := make([]byte, len(geoKeys)*2)
+	for i, k := range geoKeys {
+		bo.PutUint16(geoKeyBytes[i*2:], k)
+	}
+	tags = append(tags, tagValue{tag: tagGeoKeyDirectory, dtype: tiffShort, count: uint32(len(geoKeys)), out: geoKeyBytes})
+
+	if r.HasNoData {
+		tags = append(tags, tagValue{
+			tag: tagGDALNoData, dtype: tiffASCII, count: uint32(len(noDataStr) + 1),
+			out: append([]byte(noDataStr), 0),
+		})
+	}
+
+	// Lay out the file: header | pixel data | IFD | out-of-line tag values.
+	ifdOffset := pixelDataOffset + pixelDataLen
+	ifdSize := uint32(2 + len(tags)*12 + 4)
+	valueOffset := ifdOffset + ifdSize
+
+	for i := range tags {
+		if tags[i].out == nil {
+			continue
+		}
+		tags[i].inlineVal = valueOffset
+		valueOffset += uint32(len(tags[i].out))
+		// TIFF values must start on a word (even-byte) boundary.
+		if valueOffset%2 != 0 {
+			valueOffset++
+		}
+	}
+
+	// Header
+	if _, err := f.Write([]byte("II")); err != nil {
+		return err
+	}
+	if err := binary.Write(f, bo, uint16(42)); err != nil {
+		return err
+	}
+	if err := binary.Write(f, bo, ifdOffset); err != nil {
+		return err
+	}
+
+	// Pixel data
+	pixelBuf := make([]byte, pixelDataLen)
+	for i, v := range r.Data {
+		bo.PutUint32(pixelBuf[i*4:], math.Float32bits(v))
+	}
+	if _, err := f.Write(pixelBuf); err != nil {
+		return err
+	}
+
+	// IFD
+	if err := binary.Write(f, bo, uint16(len(tags))); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if err := binary.Write(f, bo, t.tag); err != nil {
+			return err
+		}
+		if err := binary.Write(f, bo, t.dtype); err != nil {
+			return err
+		}
+		if err := binary.Write(f, bo, t.count); err != nil {
+			return err
+		}
+		if err := binary.Write(f, bo, t.inlineVal); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(f, bo, uint32(0)); err != nil { // next IFD offset: none
+		return err
+	}
+
+	// Out-of-line tag values, in the same order their offsets were assigned.
+	for _, t := range tags {
+		if t.out == nil {
+			continue
+		}
+		if _, err := f.Write(t.out); err != nil {
+			return err
+		}
+		if len(t.out)%2 != 0 {
+			if _, err := f.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -17,14 +17,27 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"forest-bd-viewer/internal/geo/proj"
+	"forest-bd-viewer/internal/observability"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ── WFS tile index types ─────────────────────────────────────────────────────
 
 const (
 	wfsBaseURL    = "https://data.geopf.fr/wfs/ows"
-	maxLidarTiles = 25 // safety cap: max tiles per request
+	maxLidarTiles = 25 // safety cap: max WFS tiles per region
 	tileCacheDir  = "/tmp/lidar-cache"
+
+	// maxSubdivideDepth bounds the quadrant-splitting recursion so a
+	// pathological polygon (or an IGN WFS outage that reports an inflated
+	// tile count) can't recurse indefinitely.
+	maxSubdivideDepth = 6
+	// regionWorkerConcurrency bounds how many subdivided regions are
+	// processed (downloaded + CHM'd) at once.
+	regionWorkerConcurrency = 4
 )
 
 // WFSTile represents a single MNS or MNT tile from the IGN WFS tile index.
@@ -75,94 +88,75 @@ type LidarResult struct {
 
 // AnalyzeLidar fetches LIDAR HD MNS and MNT tiles for the polygon,
 // computes CHM = MNS - MNT, clips to the polygon bbox, and returns stats.
+//
+// Polygons requiring more than maxLidarTiles WFS tiles are no longer
+// rejected: the bbox is recursively split into quadrants until each
+// sub-region fits under the cap, every sub-region is processed by a bounded
+// worker pool, and the resulting CHM statistics and mosaic are merged.
 func AnalyzeLidar(ctx context.Context, geojsonGeom string) (*LidarResult, error) {
-	// Parse polygon bbox
 	bbox, err := geojsonBBox(geojsonGeom)
 	if err != nil {
 		return nil, fmt.Errorf("lidar: %w", err)
 	}
 
-	// Query WFS for MNS tiles
-	mnsTiles, err := queryWFSTiles(ctx, "IGNF_MNS-LIDAR-HD:dalle", bbox)
-	if err != nil {
-		return nil, fmt.Errorf("lidar: querying MNS tiles: %w", err)
-	}
-
-	if len(mnsTiles) == 0 {
-		return &LidarResult{
-			HasCoverage: false,
-			Message:     "No LIDAR HD coverage available for this area. LIDAR HD data is being progressively published by IGN and does not yet cover all of France.",
-		}, nil
-	}
-
-	if len(mnsTiles) > maxLidarTiles {
-		return &LidarResult{
-			HasCoverage: false,
-			Message:     fmt.Sprintf("Area too large: %d LIDAR tiles required (max %d). Please draw a smaller polygon.", len(mnsTiles), maxLidarTiles),
-		}, nil
-	}
-
-	// Query matching MNT tiles
-	mntTiles, err := queryWFSTiles(ctx, "IGNF_MNT-LIDAR-HD:dalle", bbox)
+	regions, err := planLidarRegions(ctx, bbox, 0)
 	if err != nil {
-		return nil, fmt.Errorf("lidar: querying MNT tiles: %w", err)
-	}
-
-	// Match MNS tiles to MNT tiles by grid position (name pattern)
-	mnsPairs, mntPairs := matchTilePairs(mnsTiles, mntTiles)
-	if len(mnsPairs) == 0 {
-		return &LidarResult{
-			HasCoverage: false,
-			Message:     "LIDAR HD MNS tiles found but matching MNT tiles are missing.",
-		}, nil
+		return nil, fmt.Errorf("lidar: planning tile regions: %w", err)
 	}
 
-	// Download and parse tiles (parallel)
 	os.MkdirAll(tileCacheDir, 0755)
 
-	type tileResult struct {
-		raster *Raster
-		err    error
-	}
-	nPairs := len(mnsPairs)
-	mnsResults := make([]tileResult, nPairs)
-	mntResults := make([]tileResult, nPairs)
+	// A physical LIDAR tile whose footprint straddles the split line between
+	// two adjacent quadrants satisfies both quadrants' WFS BBOX filters.
+	// claimedTiles tracks which tile names a region has already claimed, so
+	// a sibling region skips it instead of downloading and CHM'ing it a
+	// second time (which would double-count its pixels in the merged stats
+	// below).
+	var claimedTiles sync.Map
 
+	outcomes := make([]lidarRegionOutcome, len(regions))
+	sem := make(chan struct{}, regionWorkerConcurrency)
 	var wg sync.WaitGroup
-	wg.Add(nPairs * 2)
-	for i := range mnsPairs {
-		go func(idx int) {
-			defer wg.Done()
-			r, e := downloadAndParseTile(ctx, mnsPairs[idx])
-			mnsResults[idx] = tileResult{r, e}
-		}(i)
-		go func(idx int) {
+	wg.Add(len(regions))
+	for i, region := range regions {
+		go func(idx int, rb [4]float64) {
 			defer wg.Done()
-			r, e := downloadAndParseTile(ctx, mntPairs[idx])
-			mntResults[idx] = tileResult{r, e}
-		}(i)
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			outcomes[idx] = fetchRegionCHM(ctx, rb, &claimedTiles)
+		}(i, region)
 	}
 	wg.Wait()
 
-	mnsRasters := make([]*Raster, 0, nPairs)
-	mntRasters := make([]*Raster, 0, nPairs)
-	for i := 0; i < nPairs; i++ {
-		if mnsResults[i].err != nil {
-			return nil, fmt.Errorf("lidar: downloading MNS tile %s: %w", mnsPairs[i].Name, mnsResults[i].err)
+	var chmRasters []*Raster
+	var noCoverageMsg string
+	for _, o := range outcomes {
+		if o.err != nil {
+			return nil, fmt.Errorf("lidar: %w", o.err)
 		}
-		if mntResults[i].err != nil {
-			return nil, fmt.Errorf("lidar: downloading MNT tile %s: %w", mntPairs[i].Name, mntResults[i].err)
+		if len(o.chmTiles) == 0 {
+			if o.message != "" {
+				noCoverageMsg = o.message
+			}
+			continue
 		}
-		mnsRasters = append(mnsRasters, mnsResults[i].raster)
-		mntRasters = append(mntRasters, mntResults[i].raster)
+		chmRasters = append(chmRasters, o.chmTiles...)
 	}
 
-	// Compute CHM for each pair and merge stats
+	if len(chmRasters) == 0 {
+		if noCoverageMsg == "" {
+			noCoverageMsg = "No LIDAR HD coverage available for this area. LIDAR HD data is being progressively published by IGN and does not yet cover all of France."
+		}
+		return &LidarResult{HasCoverage: false, Message: noCoverageMsg}, nil
+	}
+
+	// Merge stats across every sub-region's CHM raster. Concatenating raw
+	// per-pixel values before averaging/sorting is equivalent to a
+	// pixel-count-weighted merge, and gives an exact (not sketched) median.
 	var allCHM []float32
 	var mergedBBox [4]float64
 	first := true
-	for i := range mnsRasters {
-		chm := computeCHM(mnsRasters[i], mntRasters[i])
+	for _, chm := range chmRasters {
 		for _, v := range chm.Data {
 			if chm.HasNoData && v == chm.NoData {
 				continue
@@ -225,26 +219,22 @@ func AnalyzeLidar(ctx context.Context, geojsonGeom string) (*LidarResult, error)
 	meanH := sum / float64(len(canopyVals))
 	medianH := float64(canopyVals[len(canopyVals)/2])
 
-	// Generate CHM image from the first pair (or mosaic for multiple)
+	// Stitch every sub-region's CHM raster into one mosaic, placed by its
+	// own BBox/EPSG, and render that as the CHM image.
 	imageID := fmt.Sprintf("chm_%d", time.Now().UnixNano())
-	var chmForImage *Raster
-	if len(mnsRasters) == 1 {
-		chmForImage = computeCHM(mnsRasters[0], mntRasters[0])
-	} else {
-		// Use the first tile for the image (simplification for multi-tile)
-		chmForImage = computeCHM(mnsRasters[0], mntRasters[0])
-	}
-	if err := generateCHMImage(chmForImage, imageID, maxH); err != nil {
+	mosaic := stitchCHMMosaic(chmRasters)
+	if err := generateCHMImage(mosaic, imageID, maxH); err != nil {
 		return nil, fmt.Errorf("lidar: generating CHM image: %w", err)
 	}
+	tifPath := filepath.Join(tileCacheDir, imageID+".tif")
+	if err := WriteGeoTIFF(tifPath, mosaic); err != nil {
+		return nil, fmt.Errorf("lidar: generating CHM GeoTIFF: %w", err)
+	}
 
-	// Convert bbox from native CRS to approximate EPSG:4326 if needed
-	boundsWGS84 := estimateWGS84Bounds(mergedBBox, mnsRasters[0].EPSG)
-
-	// Safety: ensure bounds are valid WGS84 (not raw projected coordinates)
-	if !isValidWGS84(boundsWGS84) {
-		// Fallback: use the input polygon bbox instead of tile-derived bounds
-		boundsWGS84 = bbox
+	// Reproject the mosaic bbox from its native CRS to WGS84 via PROJ.
+	boundsWGS84, err := proj.TransformBBox(mergedBBox, chmRasters[0].EPSG)
+	if err != nil {
+		return nil, fmt.Errorf("lidar: reprojecting CHM bounds: %w", err)
 	}
 
 	return &LidarResult{
@@ -258,8 +248,200 @@ func AnalyzeLidar(ctx context.Context, geojsonGeom string) (*LidarResult, error)
 	}, nil
 }
 
+// lidarRegionOutcome is the result of processing one (possibly subdivided)
+// bbox region: either a set of computed CHM rasters, a human-readable reason
+// there's nothing usable (no coverage / unmatched tiles), or a hard error.
+type lidarRegionOutcome struct {
+	chmTiles []*Raster
+	message  string
+	err      error
+}
+
+// planLidarRegions recursively splits bbox into quadrants until each
+// leaf region requires no more than maxLidarTiles WFS tiles (or the
+// recursion depth cap is hit, in which case the oversize region is
+// processed as-is rather than splitting forever).
+func planLidarRegions(ctx context.Context, bbox [4]float64, depth int) ([][4]float64, error) {
+	tiles, err := queryWFSTiles(ctx, "IGNF_MNS-LIDAR-HD:dalle", bbox)
+	if err != nil {
+		return nil, fmt.Errorf("querying MNS tile count: %w", err)
+	}
+	if len(tiles) <= maxLidarTiles || depth >= maxSubdivideDepth {
+		return [][4]float64{bbox}, nil
+	}
+
+	var regions [][4]float64
+	for _, quadrant := range subdivideBBox(bbox) {
+		sub, err := planLidarRegions(ctx, quadrant, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, sub...)
+	}
+	return regions, nil
+}
+
+// subdivideBBox splits bbox into four equal quadrants.
+func subdivideBBox(bbox [4]float64) [][4]float64 {
+	midLon := (bbox[0] + bbox[2]) / 2
+	midLat := (bbox[1] + bbox[3]) / 2
+	return [][4]float64{
+		{bbox[0], bbox[1], midLon, midLat},
+		{midLon, bbox[1], bbox[2], midLat},
+		{bbox[0], midLat, midLon, bbox[3]},
+		{midLon, midLat, bbox[2], bbox[3]},
+	}
+}
+
+// fetchRegionCHM queries, downloads, and computes CHM rasters for every
+// matched MNS/MNT tile pair covering one bbox region. claimedTiles is shared
+// across every region AnalyzeLidar is processing concurrently; a tile name
+// already claimed by a sibling region (because the tile's footprint
+// straddles both regions' bboxes) is skipped here rather than re-downloaded
+// and double-counted.
+func fetchRegionCHM(ctx context.Context, bbox [4]float64, claimedTiles *sync.Map) lidarRegionOutcome {
+	mnsTiles, err := queryWFSTiles(ctx, "IGNF_MNS-LIDAR-HD:dalle", bbox)
+	if err != nil {
+		return lidarRegionOutcome{err: fmt.Errorf("querying MNS tiles: %w", err)}
+	}
+
+	unclaimed := mnsTiles[:0:0]
+	for _, t := range mnsTiles {
+		if _, alreadyClaimed := claimedTiles.LoadOrStore(t.Name, struct{}{}); !alreadyClaimed {
+			unclaimed = append(unclaimed, t)
+		}
+	}
+	mnsTiles = unclaimed
+
+	if len(mnsTiles) == 0 {
+		return lidarRegionOutcome{}
+	}
+
+	mntTiles, err := queryWFSTiles(ctx, "IGNF_MNT-LIDAR-HD:dalle", bbox)
+	if err != nil {
+		return lidarRegionOutcome{err: fmt.Errorf("querying MNT tiles: %w", err)}
+	}
+
+	mnsPairs, mntPairs := matchTilePairs(mnsTiles, mntTiles)
+	if len(mnsPairs) == 0 {
+		return lidarRegionOutcome{message: "LIDAR HD MNS tiles found but matching MNT tiles are missing."}
+	}
+
+	type tileResult struct {
+		raster *Raster
+		err    error
+	}
+	nPairs := len(mnsPairs)
+	mnsResults := make([]tileResult, nPairs)
+	mntResults := make([]tileResult, nPairs)
+
+	var wg sync.WaitGroup
+	wg.Add(nPairs * 2)
+	for i := range mnsPairs {
+		go func(idx int) {
+			defer wg.Done()
+			r, e := downloadAndParseTile(ctx, mnsPairs[idx])
+			mnsResults[idx] = tileResult{r, e}
+		}(i)
+		go func(idx int) {
+			defer wg.Done()
+			r, e := downloadAndParseTile(ctx, mntPairs[idx])
+			mntResults[idx] = tileResult{r, e}
+		}(i)
+	}
+	wg.Wait()
+
+	chmTiles := make([]*Raster, 0, nPairs)
+	for i := 0; i < nPairs; i++ {
+		if mnsResults[i].err != nil {
+			return lidarRegionOutcome{err: fmt.Errorf("downloading MNS tile %s: %w", mnsPairs[i].Name, mnsResults[i].err)}
+		}
+		if mntResults[i].err != nil {
+			return lidarRegionOutcome{err: fmt.Errorf("downloading MNT tile %s: %w", mntPairs[i].Name, mntResults[i].err)}
+		}
+		chmTiles = append(chmTiles, computeCHM(mnsResults[i].raster, mntResults[i].raster))
+	}
+
+	return lidarRegionOutcome{chmTiles: chmTiles}
+}
+
+// stitchCHMMosaic places every CHM raster into one mosaic using each tile's
+// own BBox for positioning, rather than rendering only the first tile. All
+// tiles are assumed to share the same pixel resolution and orientation,
+// which holds for IGN LIDAR HD's fixed tile grid.
+func stitchCHMMosaic(tiles []*Raster) *Raster {
+	if len(tiles) == 1 {
+		return tiles[0]
+	}
+
+	union := tiles[0].BBox
+	for _, t := range tiles[1:] {
+		if t.BBox[0] < union[0] {
+			union[0] = t.BBox[0]
+		}
+		if t.BBox[1] < union[1] {
+			union[1] = t.BBox[1]
+		}
+		if t.BBox[2] > union[2] {
+			union[2] = t.BBox[2]
+		}
+		if t.BBox[3] > union[3] {
+			union[3] = t.BBox[3]
+		}
+	}
+
+	ref := tiles[0]
+	scaleX := (ref.BBox[2] - ref.BBox[0]) / float64(ref.Width)
+	scaleY := (ref.BBox[3] - ref.BBox[1]) / float64(ref.Height)
+	if scaleX <= 0 || scaleY <= 0 {
+		return ref
+	}
+
+	width := int(math.Round((union[2] - union[0]) / scaleX))
+	height := int(math.Round((union[3] - union[1]) / scaleY))
+
+	mosaic := &Raster{
+		Width:     width,
+		Height:    height,
+		Data:      make([]float32, width*height),
+		NoData:    -9999,
+		HasNoData: true,
+		BBox:      union,
+		EPSG:      ref.EPSG,
+	}
+	for i := range mosaic.Data {
+		mosaic.Data[i] = mosaic.NoData
+	}
+
+	for _, t := range tiles {
+		offsetX := int(math.Round((t.BBox[0] - union[0]) / scaleX))
+		offsetY := int(math.Round((union[3] - t.BBox[3]) / scaleY)) // rows run north→south
+		for y := 0; y < t.Height; y++ {
+			my := offsetY + y
+			if my < 0 || my >= mosaic.Height {
+				continue
+			}
+			for x := 0; x < t.Width; x++ {
+				mx := offsetX + x
+				if mx < 0 || mx >= mosaic.Width {
+					continue
+				}
+				mosaic.Data[my*mosaic.Width+mx] = t.Data[y*t.Width+x]
+			}
+		}
+	}
+
+	return mosaic
+}
+
 // ServeCHMImage returns the path to a generated CHM PNG image.
-func ServeCHMImage(imageID string) (string, error) {
+func ServeCHMImage(ctx context.Context, imageID string) (string, error) {
+	start := time.Now()
+	_, span := observability.StartSpan(ctx, "geo.ServeCHMImage",
+		attribute.String("image.id", imageID))
+	defer span.End()
+	defer func() { observability.ObserveQueryDuration("ServeCHMImage", time.Since(start).Seconds()) }()
+
 	path := filepath.Join(tileCacheDir, imageID+".png")
 	if _, err := os.Stat(path); err != nil {
 		return "", fmt.Errorf("CHM image not found: %s", imageID)
@@ -267,6 +449,22 @@ func ServeCHMImage(imageID string) (string, error) {
 	return path, nil
 }
 
+// ServeCHMGeoTIFF returns the path to the georeferenced GeoTIFF raster
+// generated alongside the CHM PNG for imageID.
+func ServeCHMGeoTIFF(ctx context.Context, imageID string) (string, error) {
+	start := time.Now()
+	_, span := observability.StartSpan(ctx, "geo.ServeCHMGeoTIFF",
+		attribute.String("image.id", imageID))
+	defer span.End()
+	defer func() { observability.ObserveQueryDuration("ServeCHMGeoTIFF", time.Since(start).Seconds()) }()
+
+	path := filepath.Join(tileCacheDir, imageID+".tif")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("CHM GeoTIFF not found: %s", imageID)
+	}
+	return path, nil
+}
+
 // ── WFS queries ──────────────────────────────────────────────────────────────
 
 func queryWFSTiles(ctx context.Context, typeName string, bbox [4]float64) ([]WFSTile, error) {
@@ -617,54 +815,3 @@ func geojsonBBox(geojson string) ([4]float64, error) {
 	return [4]float64{west, south, east, north}, nil
 }
 
-// estimateWGS84Bounds converts a bbox from a projected CRS to approximate
-// WGS84 coordinates. For EPSG:2154 (Lambert 93), uses a simple affine
-// approximation suitable for France mainland.
-func estimateWGS84Bounds(bbox [4]float64, epsg int) [4]float64 {
-	if epsg == 4326 {
-		return bbox
-	}
-
-	// EPSG:2154 (RGF93 / Lambert 93) → WGS84 approximate conversion
-	if epsg == 2154 || (epsg == 0 && looksLikeLambert93(bbox)) {
-		return [4]float64{
-			lambert93ToLon(bbox[0], bbox[1]),
-			lambert93ToLat(bbox[0], bbox[1]),
-			lambert93ToLon(bbox[2], bbox[3]),
-			lambert93ToLat(bbox[2], bbox[3]),
-		}
-	}
-
-	// For other CRS, return as-is (would need proj4 for accuracy)
-	return bbox
-}
-
-// looksLikeLambert93 checks if the coordinate ranges are consistent with
-// EPSG:2154 (Lambert 93) for metropolitan France.
-// X (easting) ∈ [100 000, 1 300 000], Y (northing) ∈ [6 000 000, 7 200 000].
-func looksLikeLambert93(bbox [4]float64) bool {
-	return bbox[0] > 50000 && bbox[0] < 1400000 &&
-		bbox[1] > 5500000 && bbox[1] < 7500000 &&
-		bbox[2] > 50000 && bbox[2] < 1400000 &&
-		bbox[3] > 5500000 && bbox[3] < 7500000
-}
-
-// isValidWGS84 checks that coordinates are in the valid WGS84 range.
-func isValidWGS84(bbox [4]float64) bool {
-	return bbox[0] >= -180 && bbox[0] <= 180 &&
-		bbox[1] >= -90 && bbox[1] <= 90 &&
-		bbox[2] >= -180 && bbox[2] <= 180 &&
-		bbox[3] >= -90 && bbox[3] <= 90
-}
-
-// Approximate Lambert 93 (EPSG:2154) → WGS84 conversion.
-// Uses the IGN reference point and linear coefficients.
-// Accurate to ~10m for Ile-de-France area, sufficient for map overlays.
-func lambert93ToLon(x, y float64) float64 {
-	// Reference: E=700000, N=6600000 → lon≈3°, lat≈46.5°
-	return 3.0 + (x-700000.0)/((math.Cos(46.5*math.Pi/180))*111320.0)
-}
-
-func lambert93ToLat(x, y float64) float64 {
-	return 46.5 + (y-6600000.0)/110540.0
-}
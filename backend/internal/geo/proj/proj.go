@@ -0,0 +1,112 @@
+// Package proj provides coordinate transformations to WGS84 for the
+// projected CRSs IGN's LIDAR HD and BD Forêt products ship in, backed by
+// the system PROJ library rather than hand-rolled linear approximations.
+package proj
+
+import (
+	"fmt"
+	"sync"
+
+	goproj "github.com/twpayne/go-proj/v10"
+)
+
+// SupportedEPSG lists the projection codes this package knows how to
+// transform to WGS84: Lambert 93 (mainland France), Web Mercator, WGS84
+// itself, RGAF09/UTM 20N (Guadeloupe, Martinique), and WGS84/UTM 20N-22N
+// (Guadeloupe, Martinique, French Guiana).
+var SupportedEPSG = map[int]bool{
+	2154:  true, // RGF93 / Lambert-93
+	3857:  true, // WGS84 / Pseudo-Mercator
+	4326:  true, // WGS84
+	5490:  true, // RGAF09 / UTM zone 20N (Guadeloupe, Martinique)
+	32620: true, // WGS84 / UTM zone 20N (Guadeloupe, Martinique)
+	32621: true, // WGS84 / UTM zone 21N
+	32622: true, // WGS84 / UTM zone 22N (French Guiana)
+}
+
+// Transformer converts a single coordinate pair from its source CRS to
+// WGS84 (EPSG:4326) longitude/latitude degrees.
+type Transformer interface {
+	ToWGS84(x, y float64) (lon, lat float64, err error)
+}
+
+// identityTransformer is used for EPSG:4326 (and EPSG:0, meaning "unknown,
+// assume already geographic") so callers don't need a special case.
+type identityTransformer struct{}
+
+func (identityTransformer) ToWGS84(x, y float64) (float64, float64, error) { return x, y, nil }
+
+// pjTransformer wraps a PROJ pipeline from a projected CRS to WGS84.
+type pjTransformer struct {
+	pj *goproj.PJ
+}
+
+func (t *pjTransformer) ToWGS84(x, y float64) (float64, float64, error) {
+	coord := goproj.NewCoord(x, y, 0, 0)
+	out, err := t.pj.Forward(coord)
+	if err != nil {
+		return 0, 0, fmt.Errorf("proj: transform failed: %w", err)
+	}
+	// NormalizeForVisualization (applied in ForEPSG) guarantees the output
+	// axis order is (lon, lat) in degrees regardless of the target CRS's
+	// native axis order.
+	return out.X, out.Y, nil
+}
+
+var (
+	mu    sync.Mutex
+	cache = map[int]Transformer{}
+)
+
+// ForEPSG returns a cached Transformer from the given EPSG code to WGS84.
+// PJ transformation pipelines are expensive to construct, so one is built
+// per EPSG code and reused for the life of the process.
+func ForEPSG(epsg int) (Transformer, error) {
+	if epsg == 0 || epsg == 4326 {
+		return identityTransformer{}, nil
+	}
+	if !SupportedEPSG[epsg] {
+		return nil, fmt.Errorf("proj: unsupported EPSG:%d", epsg)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if t, ok := cache[epsg]; ok {
+		return t, nil
+	}
+
+	pj, err := goproj.NewCRSToCRS(fmt.Sprintf("EPSG:%d", epsg), "EPSG:4326", nil)
+	if err != nil {
+		return nil, fmt.Errorf("proj: creating transformer for EPSG:%d: %w", epsg, err)
+	}
+
+	t := &pjTransformer{pj: pj.NormalizeForVisualization()}
+	cache[epsg] = t
+	return t, nil
+}
+
+// TransformBBox converts a [xmin, ymin, xmax, ymax] bbox in the given EPSG
+// code to a WGS84 [west, south, east, north] bbox.
+func TransformBBox(bbox [4]float64, epsg int) ([4]float64, error) {
+	t, err := ForEPSG(epsg)
+	if err != nil {
+		return [4]float64{}, err
+	}
+
+	west, south, err := t.ToWGS84(bbox[0], bbox[1])
+	if err != nil {
+		return [4]float64{}, err
+	}
+	east, north, err := t.ToWGS84(bbox[2], bbox[3])
+	if err != nil {
+		return [4]float64{}, err
+	}
+
+	if west > east {
+		west, east = east, west
+	}
+	if south > north {
+		south, north = north, south
+	}
+	return [4]float64{west, south, east, north}, nil
+}
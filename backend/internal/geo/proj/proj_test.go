@@ -0,0 +1,89 @@
+package proj
+
+import (
+	"math"
+	"testing"
+)
+
+// ToWGS84 reference points below are the defining parameters IGN publishes
+// for each CRS (the coordinates at which a correct implementation must
+// return the projection's natural origin), rather than arbitrary surveyed
+// points — that makes them exact regardless of rounding in any particular
+// reference dataset.
+func TestForEPSG_Lambert93NaturalOrigin(t *testing.T) {
+	// EPSG:2154 (RGF93 / Lambert-93): central meridian 3°E, latitude of
+	// origin 46.5°N, false easting 700000m, false northing 6600000m. By
+	// definition the natural origin projects to exactly (falseEasting,
+	// falseNorthing).
+	tr, err := ForEPSG(2154)
+	if err != nil {
+		t.Fatalf("ForEPSG(2154): %v", err)
+	}
+
+	lon, lat, err := tr.ToWGS84(700000, 6600000)
+	if err != nil {
+		t.Fatalf("ToWGS84: %v", err)
+	}
+
+	const tol = 1e-6
+	if math.Abs(lon-3) > tol {
+		t.Errorf("lon = %v, want 3 (±%v)", lon, tol)
+	}
+	if math.Abs(lat-46.5) > tol {
+		t.Errorf("lat = %v, want 46.5 (±%v)", lat, tol)
+	}
+}
+
+func TestForEPSG_UTM20NEquatorReference(t *testing.T) {
+	// EPSG:32620 (WGS84 / UTM zone 20N, used for Guadeloupe/Martinique):
+	// central meridian 63°W, false easting 500000m, no false northing in
+	// the northern hemisphere. At the equator the easting is exactly the
+	// false easting and the central meridian is at longitude -63.
+	tr, err := ForEPSG(32620)
+	if err != nil {
+		t.Fatalf("ForEPSG(32620): %v", err)
+	}
+
+	lon, lat, err := tr.ToWGS84(500000, 0)
+	if err != nil {
+		t.Fatalf("ToWGS84: %v", err)
+	}
+
+	const tol = 1e-6
+	if math.Abs(lon-(-63)) > tol {
+		t.Errorf("lon = %v, want -63 (±%v)", lon, tol)
+	}
+	if math.Abs(lat-0) > tol {
+		t.Errorf("lat = %v, want 0 (±%v)", lat, tol)
+	}
+}
+
+func TestForEPSG_IdentityAndUnknown(t *testing.T) {
+	tr, err := ForEPSG(4326)
+	if err != nil {
+		t.Fatalf("ForEPSG(4326): %v", err)
+	}
+	lon, lat, err := tr.ToWGS84(2.5, 48.5)
+	if err != nil || lon != 2.5 || lat != 48.5 {
+		t.Errorf("ToWGS84(2.5, 48.5) = (%v, %v, %v), want (2.5, 48.5, nil)", lon, lat, err)
+	}
+
+	if _, err := ForEPSG(9999); err == nil {
+		t.Error("ForEPSG(9999) should fail for an unsupported EPSG code")
+	}
+}
+
+func TestTransformBBox_NormalizesOrder(t *testing.T) {
+	// A bbox given with its corners swapped should still come back as
+	// [west, south, east, north].
+	bbox, err := TransformBBox([4]float64{2.6, 48.9, 2.3, 48.8}, 4326)
+	if err != nil {
+		t.Fatalf("TransformBBox: %v", err)
+	}
+	if bbox[0] > bbox[2] {
+		t.Errorf("west (%v) > east (%v)", bbox[0], bbox[2])
+	}
+	if bbox[1] > bbox[3] {
+		t.Errorf("south (%v) > north (%v)", bbox[1], bbox[3])
+	}
+}
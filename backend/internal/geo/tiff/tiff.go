@@ -0,0 +1,402 @@
+// Package tiff is a low-level, format-agnostic TIFF/BigTIFF reader: it
+// walks IFDs and exposes their tags through typed accessors, without
+// knowing anything about GeoTIFF, elevation data, or pixel decoding. It
+// mirrors the split goexif/tiff uses between walking tags and interpreting
+// them — see geo.ParseGeoTIFF for the GeoTIFF-specific consumer built on
+// top of it.
+package tiff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Data types a Tag's value may have, per the TIFF 6.0 and BigTIFF specs.
+const (
+	Byte   = 1
+	ASCII  = 2
+	Short  = 3
+	Long   = 4
+	Float  = 11
+	Double = 12
+	// BigTIFF additions: 64-bit counterparts to LONG/SLONG, plus IFD8, an
+	// IFD-offset-typed LONG8 used by chained IFDs.
+	Long8  = 16
+	SLong8 = 17
+	IFD8   = 18
+)
+
+// TypeSize returns the byte width of one value of the given tag data type.
+func TypeSize(dtype uint16) int {
+	switch dtype {
+	case Byte, ASCII:
+		return 1
+	case Short:
+		return 2
+	case Long, Float:
+		return 4
+	case Double, Long8, SLong8, IFD8:
+		return 8
+	default:
+		return 1
+	}
+}
+
+// readerChunkSize is the granularity Reader fetches from its io.ReaderAt in,
+// and maxCachedChunks bounds how many of those chunks it keeps around — IFD
+// parsing touches the same handful of chunks (header, IFD, tag value
+// blocks) over and over, a few bytes at a time, so a small LRU avoids
+// re-hitting a slow backend (e.g. an HTTP range request) per field.
+const (
+	readerChunkSize = 64 * 1024
+	maxCachedChunks = 64
+)
+
+// Reader is a buffered, cached view over an io.ReaderAt: every field read
+// during IFD parsing goes through ReadAt, which serves from a small LRU of
+// fixed-size chunks instead of issuing a fresh read (or HTTP range request)
+// per 2-8 byte field.
+type Reader struct {
+	r    io.ReaderAt
+	size int64
+
+	mu    sync.Mutex
+	cache map[int64][]byte
+	lru   []int64 // chunk indices, oldest first
+}
+
+// NewReader wraps r, whose total size must be known up front to bound
+// reads.
+func NewReader(r io.ReaderAt, size int64) *Reader {
+	return &Reader{r: r, size: size, cache: make(map[int64][]byte)}
+}
+
+// Size returns the reader's total byte length.
+func (t *Reader) Size() int64 { return t.size }
+
+func (t *Reader) chunk(idx int64) ([]byte, error) {
+	t.mu.Lock()
+	if b, ok := t.cache[idx]; ok {
+		t.touchLocked(idx)
+		t.mu.Unlock()
+		return b, nil
+	}
+	t.mu.Unlock()
+
+	start := idx * readerChunkSize
+	end := start + readerChunkSize
+	if end > t.size {
+		end = t.size
+	}
+	if start >= end {
+		return nil, fmt.Errorf("tiff: chunk %d out of range", idx)
+	}
+	buf := make([]byte, end-start)
+	if _, err := t.r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("tiff: read at %d: %w", start, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cache[idx] = buf
+	t.lru = append(t.lru, idx)
+	if len(t.lru) > maxCachedChunks {
+		evict := t.lru[0]
+		t.lru = t.lru[1:]
+		delete(t.cache, evict)
+	}
+	return buf, nil
+}
+
+// touchLocked moves idx to the most-recently-used end. t.mu must be held.
+func (t *Reader) touchLocked(idx int64) {
+	for i, v := range t.lru {
+		if v == idx {
+			t.lru = append(t.lru[:i], t.lru[i+1:]...)
+			break
+		}
+	}
+	t.lru = append(t.lru, idx)
+}
+
+// ReadAt returns the n bytes at off, fetching and caching whichever
+// fixed-size chunks they fall in.
+func (t *Reader) ReadAt(off uint64, n int) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if int64(off)+int64(n) > t.size {
+		return nil, fmt.Errorf("tiff: read out of bounds (off=%d n=%d size=%d)", off, n, t.size)
+	}
+
+	startIdx := int64(off) / readerChunkSize
+	endIdx := (int64(off) + int64(n) - 1) / readerChunkSize
+	if startIdx == endIdx {
+		b, err := t.chunk(startIdx)
+		if err != nil {
+			return nil, err
+		}
+		lo := int64(off) - startIdx*readerChunkSize
+		return b[lo : lo+int64(n)], nil
+	}
+
+	out := make([]byte, n)
+	pos := 0
+	cur := off
+	remaining := n
+	for remaining > 0 {
+		idx := int64(cur) / readerChunkSize
+		b, err := t.chunk(idx)
+		if err != nil {
+			return nil, err
+		}
+		lo := int64(cur) - idx*readerChunkSize
+		take := len(b) - int(lo)
+		if take > remaining {
+			take = remaining
+		}
+		copy(out[pos:], b[lo:int(lo)+take])
+		pos += take
+		cur += uint64(take)
+		remaining -= take
+	}
+	return out, nil
+}
+
+// Header is the byte order, BigTIFF-ness, and first-IFD offset decoded from
+// a TIFF/BigTIFF file's leading bytes.
+type Header struct {
+	ByteOrder binary.ByteOrder
+	Big       bool
+	IFDOffset uint64
+}
+
+// ReadHeader detects classic (magic 42, 32-bit offsets) or BigTIFF (magic
+// 43, 64-bit offsets) framing from r's first bytes.
+func ReadHeader(r *Reader) (Header, error) {
+	header, err := r.ReadAt(0, 8)
+	if err != nil {
+		return Header{}, err
+	}
+
+	var bo binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return Header{}, fmt.Errorf("tiff: invalid byte order marker")
+	}
+
+	magic := bo.Uint16(header[2:4])
+	switch magic {
+	case 42:
+		return Header{ByteOrder: bo, Big: false, IFDOffset: uint64(bo.Uint32(header[4:8]))}, nil
+	case 43:
+		rest, err := r.ReadAt(0, 16)
+		if err != nil {
+			return Header{}, fmt.Errorf("tiff: BigTIFF header too short: %w", err)
+		}
+		offsetSize := bo.Uint16(rest[4:6])
+		if offsetSize != 8 {
+			return Header{}, fmt.Errorf("tiff: unsupported BigTIFF offset size %d", offsetSize)
+		}
+		// rest[6:8] is a reserved, always-zero uint16.
+		return Header{ByteOrder: bo, Big: true, IFDOffset: bo.Uint64(rest[8:16])}, nil
+	default:
+		return Header{}, fmt.Errorf("tiff: not a TIFF file (magic=%d)", magic)
+	}
+}
+
+// Tag is one IFD entry: its ID, data type, value count, and the 4-byte
+// (classic TIFF) or 8-byte (BigTIFF) inline value-or-offset field verbatim,
+// still in the file's byte order. Use IFD's typed accessors to resolve Raw
+// into actual values, whether they fit inline or live at an offset.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint64
+	Raw   []byte
+}
+
+// IFD is one parsed Image File Directory: every tag it contains, keyed by
+// ID, plus enough context (byte order, BigTIFF-ness, underlying reader) to
+// resolve out-of-line values and walk to the next IFD in the chain.
+type IFD struct {
+	Entries   map[uint16]Tag
+	ByteOrder binary.ByteOrder
+
+	r    *Reader
+	big  bool
+	next uint64 // offset of the next IFD, or 0 if this is the last
+}
+
+// ParseIFD reads the IFD at offset: its entry count, that many Tags, and
+// the trailing next-IFD offset.
+func ParseIFD(r *Reader, bo binary.ByteOrder, offset uint64, big bool) (*IFD, error) {
+	entrySize, countSize, nextSize := 12, 2, 4
+	if big {
+		entrySize, countSize, nextSize = 20, 8, 8
+	}
+
+	countBuf, err := r.ReadAt(offset, countSize)
+	if err != nil {
+		return nil, fmt.Errorf("tiff: reading IFD entry count: %w", err)
+	}
+	var numEntries int
+	if big {
+		numEntries = int(bo.Uint64(countBuf))
+	} else {
+		numEntries = int(bo.Uint16(countBuf))
+	}
+
+	entries := make(map[uint16]Tag, numEntries)
+	pos := offset + uint64(countSize)
+	for i := 0; i < numEntries; i++ {
+		buf, err := r.ReadAt(pos, entrySize)
+		if err != nil {
+			return nil, fmt.Errorf("tiff: reading IFD entry %d: %w", i, err)
+		}
+		var count uint64
+		var raw []byte
+		if big {
+			count = bo.Uint64(buf[4:12])
+			raw = append([]byte(nil), buf[12:20]...)
+		} else {
+			count = uint64(bo.Uint32(buf[4:8]))
+			raw = append([]byte(nil), buf[8:12]...)
+		}
+		tag := Tag{
+			ID:    bo.Uint16(buf[0:2]),
+			Type:  bo.Uint16(buf[2:4]),
+			Count: count,
+			Raw:   raw,
+		}
+		entries[tag.ID] = tag
+		pos += uint64(entrySize)
+	}
+
+	nextBuf, err := r.ReadAt(pos, nextSize)
+	if err != nil {
+		return nil, fmt.Errorf("tiff: reading next-IFD offset: %w", err)
+	}
+	var next uint64
+	if big {
+		next = bo.Uint64(nextBuf)
+	} else {
+		next = uint64(bo.Uint32(nextBuf))
+	}
+
+	return &IFD{Entries: entries, ByteOrder: bo, r: r, big: big, next: next}, nil
+}
+
+// NextIFD parses and returns the next IFD in this file's chain (GeoTIFF
+// overviews/reduced-resolution images live in sub-IFDs reached this way),
+// or (nil, nil) if this is the last one.
+func (ifd *IFD) NextIFD() (*IFD, error) {
+	if ifd.next == 0 {
+		return nil, nil
+	}
+	return ParseIFD(ifd.r, ifd.ByteOrder, ifd.next, ifd.big)
+}
+
+// Has reports whether tag id is present in the IFD.
+func (ifd *IFD) Has(id uint16) bool {
+	_, ok := ifd.Entries[id]
+	return ok
+}
+
+// valueOffset reinterprets t's inline field as the 4- or 8-byte offset it
+// holds when its value doesn't fit inline.
+func (ifd *IFD) valueOffset(t Tag) uint64 {
+	if len(t.Raw) == 8 {
+		return ifd.ByteOrder.Uint64(t.Raw)
+	}
+	return uint64(ifd.ByteOrder.Uint32(t.Raw))
+}
+
+// bytes returns tag id's raw value bytes, resolving an out-of-line value
+// through the reader if necessary.
+func (ifd *IFD) bytes(id uint16) (Tag, []byte, error) {
+	t, ok := ifd.Entries[id]
+	if !ok {
+		return Tag{}, nil, fmt.Errorf("tiff: tag %d not present", id)
+	}
+	sz := TypeSize(t.Type) * int(t.Count)
+	if sz <= len(t.Raw) {
+		return t, t.Raw, nil
+	}
+	b, err := ifd.r.ReadAt(ifd.valueOffset(t), sz)
+	if err != nil {
+		return Tag{}, nil, err
+	}
+	return t, b, nil
+}
+
+// Int reads every value of an integer-typed (BYTE/SHORT/LONG/LONG8/SLong8)
+// tag as uint64, so BigTIFF's 64-bit counts and offsets survive intact.
+func (ifd *IFD) Int(id uint16) ([]uint64, error) {
+	t, src, err := ifd.bytes(id)
+	if err != nil {
+		return nil, err
+	}
+	n := int(t.Count)
+	elemSize := TypeSize(t.Type)
+	arr := make([]uint64, n)
+	for i := 0; i < n; i++ {
+		switch t.Type {
+		case Byte:
+			arr[i] = uint64(src[i])
+		case Short:
+			arr[i] = uint64(ifd.ByteOrder.Uint16(src[i*elemSize:]))
+		case Long:
+			arr[i] = uint64(ifd.ByteOrder.Uint32(src[i*elemSize:]))
+		case Long8, SLong8, IFD8:
+			arr[i] = ifd.ByteOrder.Uint64(src[i*elemSize:])
+		default:
+			arr[i] = uint64(ifd.ByteOrder.Uint32(src[i*elemSize:]))
+		}
+	}
+	return arr, nil
+}
+
+// Float reads every value of a FLOAT/DOUBLE tag as float64.
+func (ifd *IFD) Float(id uint16) ([]float64, error) {
+	t, src, err := ifd.bytes(id)
+	if err != nil {
+		return nil, err
+	}
+	n := int(t.Count)
+	elemSize := TypeSize(t.Type)
+	arr := make([]float64, n)
+	for i := 0; i < n; i++ {
+		switch t.Type {
+		case Float:
+			arr[i] = float64(math.Float32frombits(ifd.ByteOrder.Uint32(src[i*elemSize:])))
+		default:
+			arr[i] = math.Float64frombits(ifd.ByteOrder.Uint64(src[i*elemSize:]))
+		}
+	}
+	return arr, nil
+}
+
+// ASCII reads a NUL-terminated ASCII tag's value as a string.
+func (ifd *IFD) ASCII(id uint16) (string, error) {
+	t, src, err := ifd.bytes(id)
+	if err != nil {
+		return "", err
+	}
+	n := int(t.Count)
+	if n > len(src) {
+		n = len(src)
+	}
+	end := 0
+	for end < n && src[end] != 0 {
+		end++
+	}
+	return string(src[:end]), nil
+}
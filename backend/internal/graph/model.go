@@ -0,0 +1,6 @@
+package graph
+
+// SubmitAnalysisResult is returned by the submitAnalysis mutation.
+type SubmitAnalysisResult struct {
+	JobID string `json:"jobID"`
+}
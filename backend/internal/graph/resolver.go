@@ -8,6 +8,7 @@ import (
 	"context"
 
 	"forest-bd-viewer/internal/auth"
+	"forest-bd-viewer/internal/jobs"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
@@ -26,4 +27,8 @@ func GetEchoContext(ctx context.Context) echo.Context {
 type Resolver struct {
 	DB      *pgxpool.Pool
 	AuthSvc *auth.Service
+
+	// Jobs backs the submitAnalysis mutation and analysisProgress
+	// subscription (schema.resolvers.go) — see internal/jobs.
+	Jobs *jobs.Manager
 }
@@ -0,0 +1,77 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any
+// resolver implementations will be copied through when generating and any
+// unknown code will be moved to the end.
+
+import (
+	"context"
+
+	"forest-bd-viewer/internal/auth"
+	"forest-bd-viewer/internal/graph/generated"
+	"forest-bd-viewer/internal/jobs"
+)
+
+// Health is the resolver for the health field.
+func (r *queryResolver) Health(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// SubmitAnalysis is the resolver for the submitAnalysis field.
+func (r *mutationResolver) SubmitAnalysis(ctx context.Context, geojson string) (*SubmitAnalysisResult, error) {
+	// The job runs asynchronously across the worker pool, long after this
+	// request's context is gone, so the caller's claims travel with the job
+	// (see jobs.Manager.Submit) rather than being re-derived from ctx later.
+	jobID, err := r.Jobs.Submit(ctx, geojson, nil, auth.GetUser(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &SubmitAnalysisResult{JobID: jobID}, nil
+}
+
+// AnalysisProgress is the resolver for the analysisProgress field.
+func (r *subscriptionResolver) AnalysisProgress(ctx context.Context, jobID string) (<-chan *jobs.Progress, error) {
+	progress, err := r.Jobs.Subscribe(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *jobs.Progress)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-progress:
+				if !ok {
+					return
+				}
+				p := p
+				select {
+				case out <- &p:
+				case <-ctx.Done():
+					return
+				}
+				// Every feature has now been analyzed or skipped: the job is finished.
+				if p.Done >= p.Total {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Query returns generated.QueryResolver implementation.
+func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
+
+// Mutation returns generated.MutationResolver implementation.
+func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+
+// Subscription returns generated.SubscriptionResolver implementation.
+func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
+
+type queryResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
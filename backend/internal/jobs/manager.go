@@ -0,0 +1,402 @@
+// Package jobs runs Redis-queued, multi-feature polygon analysis jobs in a
+// bounded worker pool, so a submitAnalysis request over a large
+// FeatureCollection doesn't tie up a GraphQL request's connection and
+// timeout budget for however long it takes to analyze every feature
+// sequentially. Submit enqueues one task per feature, tagged with the
+// submitting caller's identity; Run drains the queue with a configurable
+// number of workers, each running its tasks in a row-level-security-scoped
+// transaction for that task's own caller rather than a shared connection
+// (see geo.Queries.AnalyzePolygonAsUser); progress is published per feature
+// so a subscription can stream it to the frontend as the job runs.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"forest-bd-viewer/internal/auth"
+	"forest-bd-viewer/internal/geo"
+	"forest-bd-viewer/internal/geo/filter"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// queueKey is the single Redis list every worker BLPops tasks from,
+	// across all in-flight jobs.
+	queueKey = "jobs:analysis:queue"
+
+	// workerStatementTimeout bounds how long a single task's analysis
+	// transaction may run, so one runaway ST_Intersection on a pathological
+	// feature can't wedge the worker that drew it (and therefore the queue)
+	// forever.
+	workerStatementTimeout = 30 * time.Second
+
+	// popTimeout is how long BLPop blocks waiting for a task before looping
+	// back around to check ctx/shutdown. It has no effect on throughput —
+	// it only bounds shutdown latency.
+	popTimeout = 5 * time.Second
+
+	resultTTL = 24 * time.Hour
+	cancelTTL = 24 * time.Hour
+)
+
+// Progress is published to a job's Redis pub/sub channel after every
+// feature a worker finishes (or skips), so the GraphQL subscription
+// analysisProgress(jobID) can stream it straight through to the frontend.
+type Progress struct {
+	Done             int               `json:"done"`
+	Total            int               `json:"total"`
+	CurrentFeatureID string            `json:"currentFeatureId"`
+	PartialStats     *geo.PolygonStats `json:"partialStats,omitempty"`
+	Skipped          bool              `json:"skipped,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+// FeatureResult is one feature's outcome within a job's final aggregated
+// result, cached under resultKey(jobID) once every feature has been
+// processed or skipped.
+type FeatureResult struct {
+	FeatureID string            `json:"featureId"`
+	Stats     *geo.PolygonStats `json:"stats,omitempty"`
+	Skipped   bool              `json:"skipped,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// task is one feature queued for analysis, as stored (JSON-encoded) in
+// queueKey.
+type task struct {
+	JobID     string `json:"jobId"`
+	FeatureID string `json:"featureId"`
+	Geometry  string `json:"geometry"`
+}
+
+// jobMeta is the per-job bookkeeping Submit stores under metaKey(jobID), so
+// workers (which only see one task at a time) know the job's total feature
+// count, the filter every feature in it should be analyzed against, and the
+// identity of the caller who submitted it, so its features are analyzed
+// under that caller's row-level-security role rather than the worker pool's
+// own.
+type jobMeta struct {
+	Total    int            `json:"total"`
+	Filter   *filter.Filter `json:"filter,omitempty"`
+	Identity *auth.Claims   `json:"identity,omitempty"`
+}
+
+// Manager submits FeatureCollection analysis jobs to Redis and runs the
+// worker pool that drains them.
+type Manager struct {
+	queries     *geo.Queries
+	redis       *redis.Client
+	concurrency int
+}
+
+// NewManager creates a Manager with the given worker pool size. concurrency
+// <= 0 defaults to runtime.NumCPU().
+func NewManager(queries *geo.Queries, redisClient *redis.Client, concurrency int) *Manager {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	return &Manager{queries: queries, redis: redisClient, concurrency: concurrency}
+}
+
+// Submit parses geojsonFC (a GeoJSON FeatureCollection), enqueues one task
+// per feature restricted by f, and returns a job ID the caller can track via
+// Subscribe/GetResult/Cancel. identity, when non-nil, is the submitting
+// caller's auth claims; every feature in the job is analyzed under that
+// caller's row-level-security role (see geo.Queries.AnalyzePolygonAsUser)
+// rather than an unscoped pool connection.
+func (m *Manager) Submit(ctx context.Context, geojsonFC string, f *filter.Filter, identity *auth.Claims) (string, error) {
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID         json.RawMessage `json:"id,omitempty"`
+			Properties struct {
+				ID json.RawMessage `json:"id,omitempty"`
+			} `json:"properties"`
+			Geometry json.RawMessage `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(geojsonFC), &fc); err != nil {
+		return "", fmt.Errorf("invalid GeoJSON FeatureCollection: %w", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return "", fmt.Errorf("invalid GeoJSON: type must be FeatureCollection, got %q", fc.Type)
+	}
+	if len(fc.Features) == 0 {
+		return "", fmt.Errorf("FeatureCollection has no features")
+	}
+
+	jobID, err := randomJobID()
+	if err != nil {
+		return "", fmt.Errorf("generating job id: %w", err)
+	}
+
+	metaJSON, err := json.Marshal(jobMeta{Total: len(fc.Features), Filter: f, Identity: identity})
+	if err != nil {
+		return "", fmt.Errorf("encoding job metadata: %w", err)
+	}
+	if err := m.redis.Set(ctx, metaKey(jobID), metaJSON, resultTTL).Err(); err != nil {
+		return "", fmt.Errorf("storing job metadata: %w", err)
+	}
+
+	pipe := m.redis.Pipeline()
+	for i, feat := range fc.Features {
+		featureID := geo.FeatureIDOrIndex(feat.ID, feat.Properties.ID, i)
+		taskJSON, err := json.Marshal(task{JobID: jobID, FeatureID: featureID, Geometry: string(feat.Geometry)})
+		if err != nil {
+			return "", fmt.Errorf("encoding feature %s: %w", featureID, err)
+		}
+		pipe.RPush(ctx, queueKey, taskJSON)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("enqueuing job %s: %w", jobID, err)
+	}
+
+	return jobID, nil
+}
+
+// Cancel marks jobID cancelled. Workers still pop its queued tasks (so the
+// queue drains rather than leaving orphaned entries for a job nobody is
+// tracking), but record each as skipped instead of running the analysis.
+func (m *Manager) Cancel(ctx context.Context, jobID string) error {
+	return m.redis.Set(ctx, cancelKey(jobID), "1", cancelTTL).Err()
+}
+
+// Resume clears a Cancel, so tasks for jobID still queued (or re-enqueued)
+// are analyzed normally again.
+func (m *Manager) Resume(ctx context.Context, jobID string) error {
+	return m.redis.Del(ctx, cancelKey(jobID)).Err()
+}
+
+// GetResult returns the cached final results for jobID, or an error if the
+// job hasn't finished (or its result has expired).
+func (m *Manager) GetResult(ctx context.Context, jobID string) ([]FeatureResult, error) {
+	raw, err := m.redis.Get(ctx, resultKey(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("job %s has no result yet", jobID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching job %s result: %w", jobID, err)
+	}
+	var results []FeatureResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, fmt.Errorf("decoding job %s result: %w", jobID, err)
+	}
+	return results, nil
+}
+
+// Subscribe streams Progress updates for jobID as they're published by
+// whichever worker is processing its features. The returned channel closes
+// when ctx is cancelled.
+func (m *Manager) Subscribe(ctx context.Context, jobID string) (<-chan Progress, error) {
+	sub := m.redis.Subscribe(ctx, progressChannel(jobID))
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("subscribing to job %s progress: %w", jobID, err)
+	}
+
+	out := make(chan Progress)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-sub.Channel():
+				if !ok {
+					return
+				}
+				var p Progress
+				if err := json.Unmarshal([]byte(msg.Payload), &p); err != nil {
+					continue
+				}
+				select {
+				case out <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Run launches the worker pool and blocks until ctx is cancelled, draining
+// queueKey across however many jobs are in flight. It's meant to run for
+// the lifetime of the process, started once from cmd/server/main.go.
+func (m *Manager) Run(ctx context.Context) {
+	done := make(chan struct{}, m.concurrency)
+	for i := 0; i < m.concurrency; i++ {
+		go func(worker int) {
+			m.runWorker(ctx, worker)
+			done <- struct{}{}
+		}(i)
+	}
+	for i := 0; i < m.concurrency; i++ {
+		<-done
+	}
+}
+
+// runWorker pops tasks off queueKey until ctx is cancelled. Unlike a
+// long-lived worker connection, each task runs its query under its own
+// job's submitting caller — see processTask — since two tasks popped by the
+// same worker back-to-back can belong to different jobs submitted by
+// different, differently-privileged callers.
+func (m *Manager) runWorker(ctx context.Context, worker int) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		res, err := m.redis.BLPop(ctx, popTimeout, queueKey).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("jobs: worker %d: BLPop: %v", worker, err)
+			continue
+		}
+
+		var t task
+		if err := json.Unmarshal([]byte(res[1]), &t); err != nil {
+			log.Printf("jobs: worker %d: decoding task: %v", worker, err)
+			continue
+		}
+		m.processTask(ctx, t)
+	}
+}
+
+// processTask analyzes (or, if the job was cancelled, skips) one feature,
+// records its result, publishes progress, and finalizes the job once its
+// last feature has been accounted for.
+func (m *Manager) processTask(ctx context.Context, t task) {
+	meta, err := m.jobMeta(ctx, t.JobID)
+	if err != nil {
+		log.Printf("jobs: worker: loading metadata for job %s: %v", t.JobID, err)
+		return
+	}
+
+	cancelled, err := m.redis.Exists(ctx, cancelKey(t.JobID)).Result()
+	if err != nil {
+		log.Printf("jobs: worker: checking cancellation for job %s: %v", t.JobID, err)
+	}
+
+	result := FeatureResult{FeatureID: t.FeatureID}
+	progress := Progress{CurrentFeatureID: t.FeatureID, Total: meta.Total}
+
+	if cancelled > 0 {
+		result.Skipped = true
+		progress.Skipped = true
+	} else {
+		// Run as the job's submitting caller, the same way auth.Middleware
+		// would scope a request-path call to AnalyzePolygon, so row-level
+		// security on forest_parcels applies per caller instead of to the
+		// worker pool's shared role.
+		queryCtx := ctx
+		if meta.Identity != nil {
+			queryCtx = context.WithValue(ctx, auth.UserContextKey, meta.Identity)
+		}
+		stats, err := m.queries.AnalyzePolygonAsUser(queryCtx, t.Geometry, meta.Filter, workerStatementTimeout)
+		if err != nil {
+			result.Error = err.Error()
+			progress.Error = err.Error()
+		} else {
+			result.Stats = stats
+			progress.PartialStats = stats
+		}
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("jobs: worker: encoding result for job %s feature %s: %v", t.JobID, t.FeatureID, err)
+		return
+	}
+	if err := m.redis.RPush(ctx, resultsKey(t.JobID), resultJSON).Err(); err != nil {
+		log.Printf("jobs: worker: storing result for job %s feature %s: %v", t.JobID, t.FeatureID, err)
+	}
+	done, err := m.redis.Incr(ctx, doneKey(t.JobID)).Result()
+	if err != nil {
+		log.Printf("jobs: worker: incrementing done count for job %s: %v", t.JobID, err)
+	}
+	progress.Done = int(done)
+
+	if progressJSON, err := json.Marshal(progress); err == nil {
+		m.redis.Publish(ctx, progressChannel(t.JobID), progressJSON)
+	}
+
+	if int(done) >= meta.Total {
+		m.finalize(ctx, t.JobID)
+	}
+}
+
+// finalize collects every feature result recorded for jobID, caches them
+// under resultKey with a TTL, and drops the job's intermediate bookkeeping
+// keys.
+func (m *Manager) finalize(ctx context.Context, jobID string) {
+	raw, err := m.redis.LRange(ctx, resultsKey(jobID), 0, -1).Result()
+	if err != nil {
+		log.Printf("jobs: finalizing job %s: reading results: %v", jobID, err)
+		return
+	}
+
+	results := make([]FeatureResult, 0, len(raw))
+	for _, r := range raw {
+		var fr FeatureResult
+		if err := json.Unmarshal([]byte(r), &fr); err != nil {
+			continue
+		}
+		results = append(results, fr)
+	}
+
+	resultJSON, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("jobs: finalizing job %s: encoding final result: %v", jobID, err)
+		return
+	}
+	if err := m.redis.Set(ctx, resultKey(jobID), resultJSON, resultTTL).Err(); err != nil {
+		log.Printf("jobs: finalizing job %s: caching final result: %v", jobID, err)
+	}
+
+	m.redis.Del(ctx, resultsKey(jobID), doneKey(jobID), metaKey(jobID), cancelKey(jobID))
+}
+
+func (m *Manager) jobMeta(ctx context.Context, jobID string) (jobMeta, error) {
+	raw, err := m.redis.Get(ctx, metaKey(jobID)).Bytes()
+	if err != nil {
+		return jobMeta{}, err
+	}
+	var meta jobMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return jobMeta{}, err
+	}
+	return meta, nil
+}
+
+func metaKey(jobID string) string         { return "jobs:analysis:" + jobID + ":meta" }
+func resultsKey(jobID string) string      { return "jobs:analysis:" + jobID + ":results" }
+func resultKey(jobID string) string       { return "jobs:analysis:" + jobID + ":result" }
+func doneKey(jobID string) string         { return "jobs:analysis:" + jobID + ":done" }
+func cancelKey(jobID string) string       { return "jobs:analysis:" + jobID + ":cancelled" }
+func progressChannel(jobID string) string { return "jobs:analysis:" + jobID + ":progress" }
+
+// randomJobID returns a random 16-byte hex job identifier, the same scheme
+// tiles.randomLockToken uses for distributed lock tokens.
+func randomJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,125 @@
+// Package readonly implements a maintenance-mode guard that short-circuits
+// mutating requests with 503 while the backend is, for example, replaying
+// migrations (see database.RunMigrations).
+package readonly
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Guard tracks whether the API is in read-only mode and enforces it via
+// Middleware. It can be flipped at runtime (a SIGUSR1, or the admin endpoint
+// wired up in cmd/server/main.go) without a restart.
+type Guard struct {
+	enabled atomic.Bool
+
+	// allowedMutations lets specific GraphQL mutations (e.g. "login") through
+	// even in read-only mode, so operators can still authenticate.
+	allowedMutations map[string]bool
+}
+
+// New creates a Guard starting in the given state, permitting the named
+// GraphQL mutations through regardless of mode.
+func New(enabled bool, allowedMutations []string) *Guard {
+	g := &Guard{allowedMutations: make(map[string]bool, len(allowedMutations))}
+	for _, m := range allowedMutations {
+		g.allowedMutations[m] = true
+	}
+	g.enabled.Store(enabled)
+	return g
+}
+
+// Enabled reports whether read-only mode is currently active.
+func (g *Guard) Enabled() bool { return g.enabled.Load() }
+
+// Enable turns read-only mode on.
+func (g *Guard) Enable() { g.enabled.Store(true) }
+
+// Disable turns read-only mode off.
+func (g *Guard) Disable() { g.enabled.Store(false) }
+
+// Toggle flips read-only mode and returns the new state.
+func (g *Guard) Toggle() bool {
+	state := !g.enabled.Load()
+	g.enabled.Store(state)
+	return state
+}
+
+type graphQLRequestBody struct {
+	Query string `json:"query"`
+}
+
+// adminTogglePath is exempt from enforcement so an operator (or the
+// SIGUSR1/admin-endpoint toggle) can always turn read-only mode back off.
+const adminTogglePath = "/admin/readonly"
+
+// Middleware short-circuits mutating requests with 503 while the guard is
+// enabled. GET requests (tiles, health, LiDAR CHM) always pass through.
+// POST /graphql is allowed through as long as every operation in the
+// request body is a query/subscription, or a mutation in the allowlist.
+func (g *Guard) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !g.Enabled() || c.Request().Method == http.MethodGet || c.Path() == adminTogglePath {
+				return next(c)
+			}
+
+			if c.Path() == "/graphql" {
+				allowed, err := g.graphQLRequestAllowed(c)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid GraphQL request body"})
+				}
+				if allowed {
+					return next(c)
+				}
+			}
+
+			return c.JSON(http.StatusServiceUnavailable, echo.Map{
+				"error": "service is in read-only mode for maintenance",
+			})
+		}
+	}
+}
+
+// graphQLRequestAllowed parses the request body's GraphQL query and reports
+// whether every operation in it is safe to run in read-only mode: a
+// query/subscription, or a mutation on the allowlist. It restores the
+// request body afterwards so the real GraphQL handler can still read it.
+func (g *Guard) graphQLRequestAllowed(c echo.Context) (bool, error) {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return false, err
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	var req graphQLRequestBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false, err
+	}
+
+	doc, err := parser.ParseQuery(&ast.Source{Input: req.Query})
+	if err != nil {
+		return false, err
+	}
+
+	for _, op := range doc.Operations {
+		if op.Operation != ast.Mutation {
+			continue
+		}
+		for _, sel := range op.SelectionSet {
+			field, ok := sel.(*ast.Field)
+			if !ok || !g.allowedMutations[field.Name] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
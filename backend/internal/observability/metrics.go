@@ -0,0 +1,39 @@
+package observability
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// queryDuration records how long each named backend query/RPC takes, bucketed
+// finely at the sub-millisecond level since tile and polygon queries are
+// typically single-digit milliseconds and coarser buckets would hide
+// regressions.
+var queryDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "forest_bd_query_duration_seconds",
+		Help: "Duration of backend queries (PostGIS tile/analysis queries, CHM serving), by query name.",
+		Buckets: []float64{
+			0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+		},
+	},
+	[]string{"query"},
+)
+
+// ObserveQueryDuration records durationSeconds against the histogram for
+// query. Call sites typically do:
+//
+//	start := time.Now()
+//	defer func() { observability.ObserveQueryDuration("AnalyzePolygon", time.Since(start).Seconds()) }()
+func ObserveQueryDuration(query string, durationSeconds float64) {
+	queryDuration.WithLabelValues(query).Observe(durationSeconds)
+}
+
+// MetricsHandler exposes the default Prometheus registry (including
+// queryDuration and the Go/process collectors promauto registers against it)
+// as an Echo handler for GET /metrics.
+func MetricsHandler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
@@ -0,0 +1,54 @@
+package observability
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+type contextKey string
+
+// RequestIDContextKey is the context.Context key RequestIDMiddleware stores
+// the request ID under.
+const RequestIDContextKey contextKey = "request_id"
+
+// RequestIDHeader is the header checked on the way in and echoed back on the
+// way out.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware reads X-Request-ID from the incoming request, or
+// generates a ULID when absent, stashes it in the request context (where
+// StartSpan in tracing.go adds it to every span as an attribute) and
+// echoes it back in the response header. It must run after middleware.Recover()
+// so a panic still gets an ID to log against.
+func RequestIDMiddleware() echo.MiddlewareFunc {
+	entropy := ulid.Monotonic(rand.Reader, 0)
+	var mu sync.Mutex
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := c.Request().Header.Get(RequestIDHeader)
+			if id == "" {
+				mu.Lock()
+				id = ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
+				mu.Unlock()
+			}
+
+			ctx := context.WithValue(c.Request().Context(), RequestIDContextKey, id)
+			c.SetRequest(c.Request().WithContext(ctx))
+			c.Response().Header().Set(RequestIDHeader, id)
+
+			return next(c)
+		}
+	}
+}
+
+// RequestID returns the request ID stashed in ctx by RequestIDMiddleware, or
+// "" if none is present (e.g. in tests or background jobs).
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDContextKey).(string)
+	return id
+}
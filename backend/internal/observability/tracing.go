@@ -0,0 +1,87 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies this process in traces and spans exported to the
+// collector, and is also used to name the Echo instrumentation middleware.
+const ServiceName = "forest-bd-viewer-backend"
+
+// Tracer is the package-wide tracer used by every instrumented call site
+// (tile handlers, geo queries, LiDAR serving). It's a no-op until Setup
+// installs a real provider, so instrumented code never needs a nil check.
+var Tracer = otel.Tracer(ServiceName)
+
+// Setup configures the global OpenTelemetry tracer provider with an OTLP/gRPC
+// exporter pointed at otlpEndpoint and returns a shutdown func to flush and
+// close it on process exit. When otlpEndpoint is empty, tracing stays a no-op
+// (otel's default tracer provider) so local development without a collector
+// doesn't need any extra setup.
+func Setup(ctx context.Context, otlpEndpoint string) (shutdown func(context.Context) error, err error) {
+	if otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithEndpoint(otlpEndpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("observability: creating OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("observability: building resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	Tracer = tp.Tracer(ServiceName)
+
+	return tp.Shutdown, nil
+}
+
+// TileAttributes builds the common z/x/y/layer span attributes shared by the
+// tile-serving call sites.
+func TileAttributes(layer string, z, x, y int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("layer", layer),
+		attribute.Int("tile.z", z),
+		attribute.Int("tile.x", x),
+		attribute.Int("tile.y", y),
+	}
+}
+
+// RequestIDAttribute wraps the request ID stashed in ctx (if any) as a span
+// attribute, so traces can be cross-referenced with request logs.
+func RequestIDAttribute(ctx context.Context) attribute.KeyValue {
+	return attribute.String("request.id", RequestID(ctx))
+}
+
+// StartSpan starts a span named name under Tracer, pre-populated with the
+// request ID attribute from ctx plus any extra attributes.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, RequestIDAttribute(ctx))
+	return Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
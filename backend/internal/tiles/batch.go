@@ -0,0 +1,293 @@
+package tiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultBatchConcurrency bounds the number of tiles fetched from PostGIS (or
+// the PMTiles fallback) at once when no concurrency is requested.
+var defaultBatchConcurrency = runtime.NumCPU()
+
+// maxBatchTiles caps the size of a single batch export so a careless bbox +
+// zoom range can't enumerate millions of tiles in one request.
+const maxBatchTiles = 20000
+
+// batchRequest is the body of POST /tiles/foret/batch.
+type batchRequest struct {
+	// BBox is [west, south, east, north] in EPSG:4326. Mutually exclusive
+	// with Polygon — if both are set, Polygon takes precedence.
+	BBox *[4]float64 `json:"bbox,omitempty"`
+	// Polygon is a GeoJSON Polygon/MultiPolygon in EPSG:4326. Only its
+	// bounding box is used: the covered tile set is every tile intersecting
+	// that box, including tiles that do not intersect the polygon itself.
+	Polygon json.RawMessage `json:"polygon,omitempty"`
+
+	MinZoom     int `json:"minZoom"`
+	MaxZoom     int `json:"maxZoom"`
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+type tileCoord struct{ z, x, y int }
+
+// batchTileResult is produced by a worker and collected by fetchBatch for
+// WritePMTiles; err is non-nil when the tile failed to fetch.
+type batchTileResult struct {
+	coord tileCoord
+	data  []byte
+	err   error
+}
+
+// BatchExport handles POST /tiles/foret/batch. It accepts a bbox (or GeoJSON
+// polygon) plus a zoom range, fetches every covered forest tile through a
+// bounded worker pool (warming the Redis cache as a side effect, same as a
+// normal ForestTile request would), and returns a single PMTiles archive —
+// a reproducible export an operator can use to seed an offline client (or
+// reopen directly as cfg.ForestPMTilesPath) instead of issuing thousands of
+// individual /tiles/foret requests.
+func (h *Handler) BatchExport(c echo.Context) error {
+	var req batchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request body"})
+	}
+
+	if req.MinZoom < 0 || req.MaxZoom > 22 || req.MinZoom > req.MaxZoom {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid zoom range"})
+	}
+
+	var bbox [4]float64
+	switch {
+	case len(req.Polygon) > 0:
+		b, err := geojsonPolygonBBox(req.Polygon)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": fmt.Sprintf("invalid polygon: %v", err)})
+		}
+		bbox = b
+	case req.BBox != nil:
+		bbox = *req.BBox
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "bbox or polygon is required"})
+	}
+
+	coords := tileCover(bbox, req.MinZoom, req.MaxZoom)
+	if len(coords) == 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "no tiles in the requested region"})
+	}
+	if len(coords) > maxBatchTiles {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": fmt.Sprintf("region too large: %d tiles requested (max %d)", len(coords), maxBatchTiles),
+		})
+	}
+
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	results, err := h.fetchBatch(c.Request().Context(), coords, concurrency)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/vnd.pmtiles")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="foret-export.pmtiles"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	return WritePMTiles(c.Response(), results, uint8(req.MinZoom), uint8(req.MaxZoom))
+}
+
+// fetchBatch runs the bounded worker pool and collects every tile's result.
+// A PMTiles archive needs its directory written before its tile data, so
+// unlike a zip it can't be streamed out tile-by-tile as workers finish —
+// results are gathered first and handed to WritePMTiles once the whole
+// batch (bounded by maxBatchTiles) is in.
+func (h *Handler) fetchBatch(ctx context.Context, coords []tileCoord, concurrency int) ([]batchTileResult, error) {
+	jobs := make(chan tileCoord)
+	results := make(chan batchTileResult)
+
+	go func() {
+		defer close(jobs)
+		for _, c := range coords {
+			select {
+			case jobs <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for coord := range jobs {
+				data, err := h.fetchAndCacheForestTile(ctx, coord.z, coord.x, coord.y)
+				select {
+				case results <- batchTileResult{coord: coord, data: data, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	collected := make([]batchTileResult, 0, len(coords))
+	done, total := 0, len(coords)
+	for done < total {
+		select {
+		case res := <-results:
+			done++
+			collected = append(collected, res)
+		case <-ctx.Done():
+			// Producer and workers are exiting via their own ctx.Done()
+			// branches, so fewer than `total` results will ever arrive —
+			// without this case we'd block on <-results forever.
+			return nil, ctx.Err()
+		}
+	}
+
+	return collected, nil
+}
+
+// fetchAndCacheForestTile fetches one forest tile the same way ForestTile
+// does (PMTiles fallback, then PostGIS) and warms the Redis cache, so a
+// batch export also pre-warms the cache for subsequent live requests.
+func (h *Handler) fetchAndCacheForestTile(ctx context.Context, z, x, y int) ([]byte, error) {
+	cacheKey := fmt.Sprintf("tile:foret:%d:%d:%d", z, x, y)
+
+	if cached, err := h.redis.Get(ctx, cacheKey).Bytes(); err == nil {
+		return cached, nil
+	}
+
+	var tile []byte
+	var err error
+	if h.forestPMTiles != nil {
+		tile, err = h.forestPMTiles.Tile(z, x, y)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tile == nil {
+		tile, err = h.geo.ForestTile(ctx, z, x, y, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	storeBytes := tile
+	if storeBytes == nil {
+		storeBytes = []byte{}
+	}
+	_ = h.redis.Set(context.Background(), cacheKey, storeBytes, tileCacheTTL).Err()
+
+	return tile, nil
+}
+
+// tileCover enumerates every z/x/y tile coordinate, across [minZoom, maxZoom],
+// whose tile envelope intersects bbox ([west, south, east, north] in
+// EPSG:4326). This mirrors the simple bbox-to-tile-range enumeration used by
+// offline tile seeders (e.g. mtwebmapper's cover step), without requiring a
+// true polygon-clip per tile.
+func tileCover(bbox [4]float64, minZoom, maxZoom int) []tileCoord {
+	var coords []tileCoord
+	for z := minZoom; z <= maxZoom; z++ {
+		// Tile rows count southward, so the north edge gives the smallest y.
+		minX, minY := lonLatToTile(bbox[0], bbox[3], z)
+		maxX, maxY := lonLatToTile(bbox[2], bbox[1], z)
+		n := 1 << z
+		if minX < 0 {
+			minX = 0
+		}
+		if minY < 0 {
+			minY = 0
+		}
+		if maxX > n-1 {
+			maxX = n - 1
+		}
+		if maxY > n-1 {
+			maxY = n - 1
+		}
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
+				coords = append(coords, tileCoord{z, x, y})
+			}
+		}
+	}
+	return coords
+}
+
+// lonLatToTile converts a WGS84 lon/lat to slippy-map tile x/y at zoom z.
+func lonLatToTile(lon, lat float64, z int) (x, y int) {
+	n := math.Exp2(float64(z))
+	x = int(math.Floor((lon + 180.0) / 360.0 * n))
+	latRad := lat * math.Pi / 180.0
+	y = int(math.Floor((1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * n))
+	return
+}
+
+// geojsonPolygonBBox extracts the bounding box of a GeoJSON Polygon or
+// MultiPolygon geometry.
+func geojsonPolygonBBox(raw json.RawMessage) ([4]float64, error) {
+	var geom struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &geom); err != nil {
+		return [4]float64{}, err
+	}
+
+	var west, south, east, north float64
+	first := true
+	extend := func(lon, lat float64) {
+		if first {
+			west, east, south, north = lon, lon, lat, lat
+			first = false
+			return
+		}
+		if lon < west {
+			west = lon
+		}
+		if lon > east {
+			east = lon
+		}
+		if lat < south {
+			south = lat
+		}
+		if lat > north {
+			north = lat
+		}
+	}
+
+	switch geom.Type {
+	case "Polygon":
+		var coords [][][2]float64
+		if err := json.Unmarshal(geom.Coordinates, &coords); err != nil {
+			return [4]float64{}, err
+		}
+		for _, ring := range coords {
+			for _, c := range ring {
+				extend(c[0], c[1])
+			}
+		}
+	case "MultiPolygon":
+		var coords [][][][2]float64
+		if err := json.Unmarshal(geom.Coordinates, &coords); err != nil {
+			return [4]float64{}, err
+		}
+		for _, poly := range coords {
+			for _, ring := range poly {
+				for _, c := range ring {
+					extend(c[0], c[1])
+				}
+			}
+		}
+	default:
+		return [4]float64{}, fmt.Errorf("unsupported geometry type: %s", geom.Type)
+	}
+
+	return [4]float64{west, south, east, north}, nil
+}
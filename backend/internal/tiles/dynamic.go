@@ -0,0 +1,88 @@
+package tiles
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"forest-bd-viewer/internal/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DynamicTile handles GET /tiles/:id/:z/:x/:y.mvt, serving any tileset
+// registered in the Handler's TilesetRegistry by ID — the only way tiles are
+// served; adding a new PostGIS- or file-backed layer only requires a
+// registry.Register call, not a new route and handler method. Tilesets that
+// implement filterableTileset additionally honor the ?filter= query
+// parameter (see geo/filter).
+func (h *Handler) DynamicTile(c echo.Context) error {
+	id := c.Param("id")
+	ts, ok := h.registry.Get(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("unknown tileset %q", id)})
+	}
+
+	if ts.AuthRequired() && auth.GetUser(c.Request().Context()) == nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "authentication required"})
+	}
+
+	z, x, y, err := parseTileParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid tile coordinates"})
+	}
+	if z < ts.MinZoom() || z > ts.MaxZoom() {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	f, err := parseTileFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	cacheKey := fmt.Sprintf("tile:%s:%d:%d:%d", id, z, x, y)
+	if hash := f.Hash(); hash != "" {
+		cacheKey += ":" + hash
+	}
+	return h.serveTile(c, cacheKey, ts.CacheTTL(), func(ctx context.Context) ([]byte, error) {
+		if fts, ok := ts.(filterableTileset); ok {
+			return fts.TileFiltered(ctx, z, x, y, f)
+		}
+		if !f.IsEmpty() {
+			return nil, fmt.Errorf("tileset %q does not support filtering", id)
+		}
+		return ts.Tile(ctx, z, x, y)
+	})
+}
+
+// TileJSON handles GET /tiles/:id.json, returning a minimal TileJSON
+// document describing the registered tileset so clients (MapLibre, etc.)
+// can add the layer without hardcoding its zoom range or tile URL template.
+func (h *Handler) TileJSON(c echo.Context) error {
+	// Echo names a path parameter up to the next '/', so the route's one
+	// parameter is "id.json" and holds the whole segment, e.g. "foret.json".
+	id := strings.TrimSuffix(c.Param("id.json"), ".json")
+	ts, ok := h.registry.Get(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": fmt.Sprintf("unknown tileset %q", id)})
+	}
+
+	meta := ts.Metadata()
+	scheme := "http"
+	if c.Request().TLS != nil {
+		scheme = "https"
+	}
+	tileURL := fmt.Sprintf("%s://%s/tiles/%s/{z}/{x}/{y}.mvt", scheme, c.Request().Host, id)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"tilejson":    "3.0.0",
+		"name":        meta.Name,
+		"description": meta.Description,
+		"attribution": meta.Attribution,
+		"scheme":      "xyz",
+		"tiles":       []string{tileURL},
+		"minzoom":     ts.MinZoom(),
+		"maxzoom":     ts.MaxZoom(),
+	})
+}
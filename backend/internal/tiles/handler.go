@@ -2,32 +2,78 @@ package tiles
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
-	"forest-bd-viewer/internal/auth"
 	"forest-bd-viewer/internal/geo"
+	"forest-bd-viewer/internal/geo/filter"
 
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	tileCacheTTL      = 24 * time.Hour
 	adminTileCacheTTL = 7 * 24 * time.Hour // admin boundaries rarely change
+
+	// tileLockTTL bounds how long a distributed Redis lock on a cold tile is
+	// held before another replica is allowed to take over (e.g. because the
+	// lock holder crashed mid-fetch).
+	tileLockTTL = 30 * time.Second
+	// tileLockPollInterval is how often a replica that lost the lock race
+	// re-checks the cache for the result the lock holder is computing.
+	tileLockPollInterval = 50 * time.Millisecond
 )
 
 // Handler serves MVT tile endpoints.
 type Handler struct {
 	geo   *geo.Queries
 	redis *redis.Client
+
+	// forestPMTiles, when set, is consulted before falling back to the
+	// PostGIS-backed ForestTile query. This lets operators pre-bake heavy
+	// forest tiles offline into a .pmtiles archive and ship it instead of
+	// querying PostGIS on every cache miss.
+	forestPMTiles *PMTilesSource
+
+	// registry backs the dynamic /tiles/{id}/{z}/{x}/{y}.mvt mux. Populated
+	// by RegisterBuiltins and, optionally, grown at runtime by registering
+	// additional file- or PostGIS-backed tilesets.
+	registry *TilesetRegistry
+
+	// flight coalesces concurrent in-process requests for the same cold
+	// tile into a single fetch, keyed by cacheKey.
+	flight singleflight.Group
 }
 
 // NewHandler creates a Handler with the given geo queries and Redis client.
 func NewHandler(geoQueries *geo.Queries, redisClient *redis.Client) *Handler {
-	return &Handler{geo: geoQueries, redis: redisClient}
+	return &Handler{geo: geoQueries, redis: redisClient, registry: NewTilesetRegistry()}
+}
+
+// Registry exposes the Handler's TilesetRegistry so callers can register
+// additional tilesets (e.g. file-backed ones discovered by a directory
+// watch) without restarting the process.
+func (h *Handler) Registry() *TilesetRegistry {
+	return h.registry
+}
+
+// SetForestPMTiles installs a PMTiles archive as the ForestTile source. When
+// nil (the default), ForestTile always queries PostGIS.
+func (h *Handler) SetForestPMTiles(src *PMTilesSource) {
+	h.forestPMTiles = src
+}
+
+// parseTileFilter parses the optional ?filter= query parameter (see
+// geo/filter for the expression grammar) and returns a 400-appropriate error
+// when it doesn't parse.
+func parseTileFilter(c echo.Context) (*filter.Filter, error) {
+	return filter.Parse(c.QueryParam("filter"))
 }
 
 // parseTileParams extracts and validates z, x, y from Echo path parameters.
@@ -59,6 +105,12 @@ func parseTileParams(c echo.Context) (z, x, y int, err error) {
 
 // serveTile checks the Redis cache, falls back to the provided fetch function,
 // caches the result, and writes the MVT response.
+//
+// Concurrent requests for the same cold tile are coalesced twice over: an
+// in-process golang.org/x/sync/singleflight group ensures only one goroutine
+// per process calls fetch, and a short-lived Redis lock (SET NX) ensures
+// only one replica behind a load balancer does, with the rest polling the
+// cache for the result the lock holder is computing.
 func (h *Handler) serveTile(c echo.Context, cacheKey string, ttl time.Duration,
 	fetch func(ctx context.Context) ([]byte, error)) error {
 
@@ -73,74 +125,107 @@ func (h *Handler) serveTile(c echo.Context, cacheKey string, ttl time.Duration,
 		return c.Blob(http.StatusOK, "application/x-protobuf", cached)
 	}
 
-	// Cache miss — query PostGIS
-	tile, err := fetch(ctx)
+	tile, err, _ := h.flight.Do(cacheKey, func() (interface{}, error) {
+		return h.fetchWithDistributedLock(ctx, cacheKey, ttl, fetch)
+	})
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "tile generation failed"})
 	}
 
-	// Cache result (empty tiles stored as empty byte slice to avoid thundering herd)
-	storeBytes := tile
-	if storeBytes == nil {
-		storeBytes = []byte{}
-	}
-	_ = h.redis.Set(context.Background(), cacheKey, storeBytes, ttl).Err()
-
-	if len(tile) == 0 {
+	tileBytes, _ := tile.([]byte)
+	if len(tileBytes) == 0 {
 		return c.NoContent(http.StatusNoContent)
 	}
-	return c.Blob(http.StatusOK, "application/x-protobuf", tile)
+	return c.Blob(http.StatusOK, "application/x-protobuf", tileBytes)
 }
 
-// ForestTile handles GET /tiles/foret/:z/:x/:y.mvt
-// Authentication required.
-func (h *Handler) ForestTile(c echo.Context) error {
-	if auth.GetUser(c.Request().Context()) == nil {
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "authentication required"})
-	}
+// fetchWithDistributedLock acquires a short-lived Redis lock for cacheKey
+// before calling fetch, so that of all replicas racing on the same cold
+// tile, only the lock holder actually queries PostGIS/WFS; the rest poll
+// the cache until the result appears (or the lock expires and they retry
+// the race themselves).
+func (h *Handler) fetchWithDistributedLock(ctx context.Context, cacheKey string, ttl time.Duration,
+	fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
 
-	z, x, y, err := parseTileParams(c)
+	lockKey := "lock:" + cacheKey
+	token, err := randomLockToken()
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid tile coordinates"})
+		return nil, fmt.Errorf("generating lock token: %w", err)
 	}
 
-	cacheKey := fmt.Sprintf("tile:foret:%d:%d:%d", z, x, y)
-	return h.serveTile(c, cacheKey, tileCacheTTL, func(ctx context.Context) ([]byte, error) {
-		return h.geo.ForestTile(ctx, z, x, y)
-	})
+	deadline := time.Now().Add(tileLockTTL)
+	for {
+		acquired, err := h.redis.SetNX(ctx, lockKey, token, tileLockTTL).Result()
+		if err != nil {
+			// Redis unavailable for locking — fail open and fetch directly
+			// rather than blocking tile serving on lock availability.
+			return h.fetchAndCache(ctx, cacheKey, ttl, fetch)
+		}
+		if acquired {
+			defer h.releaseLock(lockKey, token)
+			return h.fetchAndCache(ctx, cacheKey, ttl, fetch)
+		}
+
+		// Another replica holds the lock — wait for its result to land in
+		// the cache instead of duplicating the fetch.
+		if cached, err := h.redis.Get(ctx, cacheKey).Bytes(); err == nil {
+			return cached, nil
+		}
+		if time.Now().After(deadline) {
+			return h.fetchAndCache(ctx, cacheKey, ttl, fetch)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(tileLockPollInterval):
+		}
+	}
 }
 
-// AdminTile handles GET /tiles/admin/:layer/:z/:x/:y.mvt
-// No authentication required — admin boundaries are public data.
-// layer must be one of: regions, departements, communes.
-func (h *Handler) AdminTile(c echo.Context) error {
-	layer := c.Param("layer")
+func (h *Handler) fetchAndCache(ctx context.Context, cacheKey string, ttl time.Duration,
+	fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
 
-	z, x, y, err := parseTileParams(c)
+	tile, err := fetch(ctx)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid tile coordinates"})
+		return nil, err
 	}
 
-	cacheKey := fmt.Sprintf("tile:admin:%s:%d:%d:%d", layer, z, x, y)
-	return h.serveTile(c, cacheKey, adminTileCacheTTL, func(ctx context.Context) ([]byte, error) {
-		return h.geo.AdminTile(ctx, layer, z, x, y)
-	})
+	// Cache result (empty tiles stored as empty byte slice to avoid thundering herd)
+	storeBytes := tile
+	if storeBytes == nil {
+		storeBytes = []byte{}
+	}
+	_ = h.redis.Set(context.Background(), cacheKey, storeBytes, ttl).Err()
+
+	return tile, nil
 }
 
-// CadastreTile handles GET /tiles/cadastre/:z/:x/:y.mvt
-// Authentication required.
-func (h *Handler) CadastreTile(c echo.Context) error {
-	if auth.GetUser(c.Request().Context()) == nil {
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "authentication required"})
-	}
+// releaseLock deletes the lock key only if it still holds our token, via a
+// small Lua script, so a replica never releases a lock another replica
+// already re-acquired after ours expired.
+func (h *Handler) releaseLock(lockKey, token string) {
+	const script = `
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0
+	`
+	_ = h.redis.Eval(context.Background(), script, []string{lockKey}, token).Err()
+}
 
-	z, x, y, err := parseTileParams(c)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid tile coordinates"})
+func randomLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(buf), nil
+}
 
-	cacheKey := fmt.Sprintf("tile:cadastre:%d:%d:%d", z, x, y)
-	return h.serveTile(c, cacheKey, tileCacheTTL, func(ctx context.Context) ([]byte, error) {
-		return h.geo.CadastreTile(ctx, z, x, y)
-	})
+// filterableTileset is implemented by Tilesets whose underlying query can
+// be restricted by the ?filter= query parameter (see geo/filter) that
+// DynamicTile accepts. For tilesets that don't implement it, DynamicTile
+// rejects a non-empty filter rather than ignoring it.
+type filterableTileset interface {
+	TileFiltered(ctx context.Context, z, x, y int, f *filter.Filter) ([]byte, error)
 }
@@ -0,0 +1,371 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// PMTiles header layout (v3), 127 bytes. See
+// https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+const (
+	pmtilesMagic      = "PMTiles"
+	pmtilesHeaderSize = 127
+)
+
+// Tile compression codes used in the PMTiles header.
+const (
+	pmCompressUnknown = 0
+	pmCompressNone    = 1
+	pmCompressGzip    = 2
+	pmCompressBrotli  = 3
+	pmCompressZstd    = 4
+)
+
+// pmtilesHeader is the fixed-size header at the start of a .pmtiles archive.
+type pmtilesHeader struct {
+	RootDirOffset     uint64
+	RootDirLength     uint64
+	JSONMetaOffset    uint64
+	JSONMetaLength    uint64
+	LeafDirOffset     uint64
+	LeafDirLength     uint64
+	TileDataOffset    uint64
+	TileDataLength    uint64
+	AddressedTiles    uint64
+	TileEntries       uint64
+	TileContents      uint64
+	Clustered         bool
+	InternalCompr     byte
+	TileCompr         byte
+	TileType          byte
+	MinZoom, MaxZoom  byte
+}
+
+// pmtilesEntry is one decoded directory entry: a tile_id (or run of tile_ids)
+// mapping to a byte range in the tile data section, or to a leaf directory.
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// PMTilesSource serves tiles out of a single .pmtiles archive via range reads.
+// It is a fallback/alternative to the on-demand PostGIS tile sources: an
+// operator can pre-bake a heavy tileset offline and ship it as one file
+// instead of querying PostGIS on every cache miss.
+type PMTilesSource struct {
+	r      io.ReaderAt
+	header pmtilesHeader
+
+	mu       sync.RWMutex
+	rootDir  []pmtilesEntry
+	leafDirs map[uint64][]pmtilesEntry // keyed by directory offset
+}
+
+// NewPMTilesSource opens a PMTiles archive from r (typically an *os.File,
+// which may be backed by a memory-mapped or range-read file) and parses its
+// header and root directory.
+func NewPMTilesSource(r io.ReaderAt) (*PMTilesSource, error) {
+	hdr, err := readPMTilesHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("pmtiles: %w", err)
+	}
+
+	root, err := readPMTilesDirectory(r, int64(hdr.RootDirOffset), int64(hdr.RootDirLength), hdr.InternalCompr)
+	if err != nil {
+		return nil, fmt.Errorf("pmtiles: reading root directory: %w", err)
+	}
+
+	return &PMTilesSource{
+		r:        r,
+		header:   hdr,
+		rootDir:  root,
+		leafDirs: make(map[uint64][]pmtilesEntry),
+	}, nil
+}
+
+// Tile returns the tile bytes for the given z/x/y, or nil if the archive has
+// no tile at that coordinate. The returned bytes are transparently
+// decompressed according to the header's tile compression.
+func (s *PMTilesSource) Tile(z, x, y int) ([]byte, error) {
+	tileID := zxyToTileID(uint8(z), uint32(x), uint32(y))
+
+	entry, err := s.findEntry(tileID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	buf := make([]byte, entry.Length)
+	if _, err := s.r.ReadAt(buf, int64(s.header.TileDataOffset+entry.Offset)); err != nil {
+		return nil, fmt.Errorf("pmtiles: reading tile data: %w", err)
+	}
+
+	return decompressPMTiles(buf, s.header.TileCompr)
+}
+
+// findEntry walks the root directory and, on a leaf-directory pointer,
+// recursively fetches and searches the referenced leaf directory.
+func (s *PMTilesSource) findEntry(tileID uint64) (*pmtilesEntry, error) {
+	dir := s.rootDir
+	for depth := 0; depth < 4; depth++ { // leaf directories rarely nest more than a couple of levels deep
+		entry := searchDirectory(dir, tileID)
+		if entry == nil {
+			return nil, nil
+		}
+		if entry.RunLength > 0 {
+			return entry, nil
+		}
+
+		// RunLength == 0 marks a pointer to a leaf directory, where
+		// Offset/Length describe the leaf directory's byte range.
+		leaf, err := s.leafDirectory(entry.Offset, entry.Length)
+		if err != nil {
+			return nil, err
+		}
+		dir = leaf
+	}
+	return nil, fmt.Errorf("pmtiles: leaf directory nesting too deep")
+}
+
+func (s *PMTilesSource) leafDirectory(offset uint64, length uint32) ([]pmtilesEntry, error) {
+	s.mu.RLock()
+	if dir, ok := s.leafDirs[offset]; ok {
+		s.mu.RUnlock()
+		return dir, nil
+	}
+	s.mu.RUnlock()
+
+	dir, err := readPMTilesDirectory(s.r, int64(s.header.LeafDirOffset+offset), int64(length), s.header.InternalCompr)
+	if err != nil {
+		return nil, fmt.Errorf("pmtiles: reading leaf directory: %w", err)
+	}
+
+	s.mu.Lock()
+	s.leafDirs[offset] = dir
+	s.mu.Unlock()
+	return dir, nil
+}
+
+// searchDirectory finds the entry whose [TileID, TileID+RunLength) range
+// (or, for a leaf pointer, whose TileID itself) covers tileID.
+func searchDirectory(dir []pmtilesEntry, tileID uint64) *pmtilesEntry {
+	lo, hi := 0, len(dir)-1
+	var best *pmtilesEntry
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		if dir[mid].TileID <= tileID {
+			best = &dir[mid]
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	if best.RunLength == 0 {
+		// Leaf directory pointer — valid regardless of run length.
+		if best.TileID <= tileID {
+			return best
+		}
+		return nil
+	}
+	if tileID < best.TileID+uint64(best.RunLength) {
+		return best
+	}
+	return nil
+}
+
+func readPMTilesHeader(r io.ReaderAt) (pmtilesHeader, error) {
+	buf := make([]byte, pmtilesHeaderSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return pmtilesHeader{}, fmt.Errorf("reading header: %w", err)
+	}
+	if string(buf[:7]) != pmtilesMagic {
+		return pmtilesHeader{}, fmt.Errorf("not a PMTiles archive (bad magic)")
+	}
+
+	le := binary.LittleEndian
+	h := pmtilesHeader{
+		RootDirOffset:  le.Uint64(buf[8:16]),
+		RootDirLength:  le.Uint64(buf[16:24]),
+		JSONMetaOffset: le.Uint64(buf[24:32]),
+		JSONMetaLength: le.Uint64(buf[32:40]),
+		LeafDirOffset:  le.Uint64(buf[40:48]),
+		LeafDirLength:  le.Uint64(buf[48:56]),
+		TileDataOffset: le.Uint64(buf[56:64]),
+		TileDataLength: le.Uint64(buf[64:72]),
+		AddressedTiles: le.Uint64(buf[72:80]),
+		TileEntries:    le.Uint64(buf[80:88]),
+		TileContents:   le.Uint64(buf[88:96]),
+		Clustered:      buf[96] == 1,
+		InternalCompr:  buf[97],
+		TileCompr:      buf[98],
+		TileType:       buf[99],
+		MinZoom:        buf[100],
+		MaxZoom:        buf[101],
+	}
+	return h, nil
+}
+
+// readPMTilesDirectory reads, decompresses, and decodes a directory (root or
+// leaf) of varint-encoded entries from the archive.
+func readPMTilesDirectory(r io.ReaderAt, offset, length int64, compression byte) ([]pmtilesEntry, error) {
+	raw := make([]byte, length)
+	if _, err := r.ReadAt(raw, offset); err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decompressPMTiles(raw, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePMTilesDirectory(decompressed)
+}
+
+// decodePMTilesDirectory parses the directory wire format: a varint entry
+// count, then parallel varint arrays of tile_id (each a delta from the
+// previous entry's), run_length, length, and offset (stored as offset+1,
+// with 0 meaning "contiguous with the previous entry's data").
+func decodePMTilesDirectory(data []byte) ([]pmtilesEntry, error) {
+	buf := bytes.NewReader(data)
+
+	numEntries, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading entry count: %w", err)
+	}
+
+	entries := make([]pmtilesEntry, numEntries)
+
+	var tileID uint64
+	for i := range entries {
+		delta, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading tile_id delta %d: %w", i, err)
+		}
+		tileID += delta
+		entries[i].TileID = tileID
+	}
+
+	for i := range entries {
+		rl, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading run_length %d: %w", i, err)
+		}
+		entries[i].RunLength = uint32(rl)
+	}
+
+	for i := range entries {
+		length, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading length %d: %w", i, err)
+		}
+		entries[i].Length = uint32(length)
+	}
+
+	var prevOffset, prevLength uint64
+	for i := range entries {
+		off, err := binary.ReadUvarint(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading offset %d: %w", i, err)
+		}
+		if off == 0 && i > 0 {
+			entries[i].Offset = prevOffset + prevLength
+		} else {
+			entries[i].Offset = off - 1
+		}
+		prevOffset = entries[i].Offset
+		prevLength = uint64(entries[i].Length)
+	}
+
+	return entries, nil
+}
+
+func decompressPMTiles(data []byte, compression byte) ([]byte, error) {
+	switch compression {
+	case pmCompressNone, pmCompressUnknown:
+		return data, nil
+	case pmCompressGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip init: %w", err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case pmCompressZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("zstd init: %w", err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unsupported tile compression %d", compression)
+	}
+}
+
+// ── Hilbert curve tile addressing ────────────────────────────────────────────
+//
+// PMTiles addresses tiles by a single tile_id computed from a Hilbert curve
+// over z/x/y, which keeps spatially-adjacent tiles close together in the
+// directory and tile data section. tile_id 0 is z0/0/0; each zoom level z
+// covers the range [zoomOffset(z), zoomOffset(z+1)).
+
+func zxyToTileID(z uint8, x, y uint32) uint64 {
+	var acc uint64
+	for t := uint8(0); t < z; t++ {
+		acc += numTilesAtZoom(t)
+	}
+	n := uint32(1) << z
+	hilbert := xyToHilbertD(n, x, y)
+	return acc + uint64(hilbert)
+}
+
+func numTilesAtZoom(z uint8) uint64 {
+	n := uint64(1) << z
+	return n * n
+}
+
+// xyToHilbertD converts (x, y) on an n×n grid to its distance d along the
+// Hilbert curve, following the standard rotate-and-reflect algorithm.
+func xyToHilbertD(n, x, y uint32) uint64 {
+	var rx, ry uint32
+	var d uint64
+	for s := n / 2; s > 0; s /= 2 {
+		if (x & s) > 0 {
+			rx = 1
+		} else {
+			rx = 0
+		}
+		if (y & s) > 0 {
+			ry = 1
+		} else {
+			ry = 0
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		x, y = hilbertRotate(n, x, y, rx, ry)
+	}
+	return d
+}
+
+func hilbertRotate(n, x, y, rx, ry uint32) (uint32, uint32) {
+	if ry == 0 {
+		if rx == 1 {
+			x = n - 1 - x
+			y = n - 1 - y
+		}
+		x, y = y, x
+	}
+	return x, y
+}
@@ -0,0 +1,51 @@
+package tiles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPMTilesDirectoryRoundTrip(t *testing.T) {
+	entries := []pmtilesEntry{
+		{TileID: 0, Offset: 0, Length: 100, RunLength: 1},
+		{TileID: 1, Offset: 100, Length: 50, RunLength: 1}, // contiguous with entry 0
+		{TileID: 5, Offset: 500, Length: 20, RunLength: 1}, // non-contiguous
+	}
+
+	encoded := encodePMTilesDirectory(entries)
+	got, err := decodePMTilesDirectory(encoded)
+	if err != nil {
+		t.Fatalf("decodePMTilesDirectory: %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("decodePMTilesDirectory round-trip = %+v, want %+v", got, entries)
+	}
+}
+
+func TestPMTilesDirectoryRoundTrip_Empty(t *testing.T) {
+	got, err := decodePMTilesDirectory(encodePMTilesDirectory(nil))
+	if err != nil {
+		t.Fatalf("decodePMTilesDirectory: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("decodePMTilesDirectory(empty) = %+v, want empty", got)
+	}
+}
+
+func TestZXYToTileID(t *testing.T) {
+	cases := []struct {
+		z    uint8
+		x, y uint32
+		want uint64
+	}{
+		{0, 0, 0, 0}, // the single z0 tile
+		{1, 0, 0, 1}, // first tile after z0's single entry (hilbert distance 0)
+		{1, 1, 0, 4}, // last of the 4 tiles at z1 (acc=1, hilbert distance 3)
+		{1, 1, 1, 3}, // acc=1, hilbert distance 2
+	}
+	for _, c := range cases {
+		if got := zxyToTileID(c.z, c.x, c.y); got != c.want {
+			t.Errorf("zxyToTileID(%d,%d,%d) = %d, want %d", c.z, c.x, c.y, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WritePMTiles writes tiles as a single PMTiles v3 archive to w — the same
+// format PMTilesSource reads, so a batch export can be reopened directly as
+// a forest PMTiles source (see cfg.ForestPMTilesPath) instead of needing its
+// own ad hoc layout. Every tile is gzip-compressed and addressed by one
+// root directory (no leaf directories: the tile counts maxBatchTiles allows
+// never need the extra level PMTilesSource supports for huge archives).
+func WritePMTiles(w io.Writer, tiles []batchTileResult, minZoom, maxZoom uint8) error {
+	type keyed struct {
+		tileID uint64
+		data   []byte
+	}
+	kept := make([]keyed, 0, len(tiles))
+	for _, t := range tiles {
+		if t.err != nil || len(t.data) == 0 {
+			continue
+		}
+		id := zxyToTileID(uint8(t.coord.z), uint32(t.coord.x), uint32(t.coord.y))
+		kept = append(kept, keyed{tileID: id, data: t.data})
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].tileID < kept[j].tileID })
+
+	var tileData bytes.Buffer
+	entries := make([]pmtilesEntry, 0, len(kept))
+	for _, k := range kept {
+		compressed, err := gzipCompress(k.data)
+		if err != nil {
+			return fmt.Errorf("pmtiles: compressing tile %d: %w", k.tileID, err)
+		}
+		entries = append(entries, pmtilesEntry{
+			TileID:    k.tileID,
+			Offset:    uint64(tileData.Len()),
+			Length:    uint32(len(compressed)),
+			RunLength: 1,
+		})
+		tileData.Write(compressed)
+	}
+
+	jsonMeta, err := gzipCompress([]byte("{}"))
+	if err != nil {
+		return fmt.Errorf("pmtiles: compressing metadata: %w", err)
+	}
+	rootDir, err := gzipCompress(encodePMTilesDirectory(entries))
+	if err != nil {
+		return fmt.Errorf("pmtiles: compressing root directory: %w", err)
+	}
+
+	jsonMetaOffset := uint64(pmtilesHeaderSize)
+	rootDirOffset := jsonMetaOffset + uint64(len(jsonMeta))
+	tileDataOffset := rootDirOffset + uint64(len(rootDir))
+
+	hdr := make([]byte, pmtilesHeaderSize)
+	copy(hdr[0:7], pmtilesMagic)
+	hdr[7] = 3 // spec version
+
+	le := binary.LittleEndian
+	le.PutUint64(hdr[8:16], rootDirOffset)
+	le.PutUint64(hdr[16:24], uint64(len(rootDir)))
+	le.PutUint64(hdr[24:32], jsonMetaOffset)
+	le.PutUint64(hdr[32:40], uint64(len(jsonMeta)))
+	// No leaf directories, so hdr[40:56] (their offset/length) stays zero.
+	le.PutUint64(hdr[56:64], tileDataOffset)
+	le.PutUint64(hdr[64:72], uint64(tileData.Len()))
+	le.PutUint64(hdr[72:80], uint64(len(entries)))
+	le.PutUint64(hdr[80:88], uint64(len(entries)))
+	le.PutUint64(hdr[88:96], uint64(len(entries)))
+	hdr[96] = 1 // clustered: entries are written in tile_id order
+	hdr[97] = pmCompressGzip
+	hdr[98] = pmCompressGzip
+	hdr[99] = 1 // tile type: MVT
+	hdr[100] = minZoom
+	hdr[101] = maxZoom
+	hdr[118] = minZoom // center_zoom
+
+	for _, b := range [][]byte{hdr, jsonMeta, rootDir, tileData.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("pmtiles: writing archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodePMTilesDirectory is the inverse of decodePMTilesDirectory: a varint
+// entry count, then parallel varint tile_id (delta-encoded)/run_length/
+// length/offset (offset+1, or 0 when contiguous) arrays. entries must
+// already be sorted by TileID.
+func encodePMTilesDirectory(entries []pmtilesEntry) []byte {
+	var buf bytes.Buffer
+	putUvarint := func(v uint64) {
+		tmp := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(tmp, v)
+		buf.Write(tmp[:n])
+	}
+
+	putUvarint(uint64(len(entries)))
+
+	var prevID uint64
+	for _, e := range entries {
+		putUvarint(e.TileID - prevID)
+		prevID = e.TileID
+	}
+	for _, e := range entries {
+		putUvarint(uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		putUvarint(uint64(e.Length))
+	}
+	var prevOffset, prevLength uint64
+	for i, e := range entries {
+		if i > 0 && e.Offset == prevOffset+prevLength {
+			putUvarint(0)
+		} else {
+			putUvarint(e.Offset + 1)
+		}
+		prevOffset, prevLength = e.Offset, uint64(e.Length)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,245 @@
+package tiles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"forest-bd-viewer/internal/geo/filter"
+)
+
+// TilesetMetadata is the descriptive information surfaced by a Tileset's
+// TileJSON endpoint.
+type TilesetMetadata struct {
+	Name        string
+	Description string
+	Attribution string
+}
+
+// Tileset is anything that can answer "what's at z/x/y" as an MVT tile,
+// whether it's backed by a PostGIS query or a pre-baked PMTiles archive.
+// Registering a Tileset makes it reachable at /tiles/{id}/{z}/{x}/{y}.mvt
+// without adding a dedicated route or handler method.
+type Tileset interface {
+	Tile(ctx context.Context, z, x, y int) ([]byte, error)
+	Metadata() TilesetMetadata
+	MinZoom() int
+	MaxZoom() int
+	AuthRequired() bool
+	CacheTTL() time.Duration
+}
+
+// TilesetRegistry maps tileset IDs to Tilesets. It supports registering and
+// removing tilesets at runtime (e.g. from a config reload or a directory
+// watch for new PMTiles/MBTiles files) so operators can add layers without
+// a restart or a Go code change.
+type TilesetRegistry struct {
+	mu   sync.RWMutex
+	sets map[string]Tileset
+}
+
+// NewTilesetRegistry returns an empty registry.
+func NewTilesetRegistry() *TilesetRegistry {
+	return &TilesetRegistry{sets: make(map[string]Tileset)}
+}
+
+// Register adds or replaces the tileset under id.
+func (r *TilesetRegistry) Register(id string, ts Tileset) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sets[id] = ts
+}
+
+// Unregister removes the tileset under id, if present.
+func (r *TilesetRegistry) Unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sets, id)
+}
+
+// Get returns the tileset registered under id, if any.
+func (r *TilesetRegistry) Get(id string) (Tileset, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ts, ok := r.sets[id]
+	return ts, ok
+}
+
+// IDs returns the currently registered tileset IDs.
+func (r *TilesetRegistry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.sets))
+	for id := range r.sets {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// WatchPMTilesDir polls dir every interval for *.pmtiles files and registers
+// any that aren't already known, using the file's base name (without
+// extension) as the tileset ID. It runs until ctx is cancelled. This gives
+// operators hot-reload for file-backed layers (drop a new .pmtiles file in
+// dir and it becomes servable) without a config push or restart.
+func (r *TilesetRegistry) WatchPMTilesDir(ctx context.Context, dir string, interval time.Duration) {
+	scan := func() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pmtiles") {
+				continue
+			}
+			id := strings.TrimSuffix(entry.Name(), ".pmtiles")
+			if _, ok := r.Get(id); ok {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			f, err := os.Open(path)
+			if err != nil {
+				log.Printf("tiles: registry watch: opening %s: %v", path, err)
+				continue
+			}
+			src, err := NewPMTilesSource(f)
+			if err != nil {
+				log.Printf("tiles: registry watch: parsing %s: %v", path, err)
+				f.Close()
+				continue
+			}
+			r.Register(id, &pmtilesTileset{
+				source: src,
+				meta:   TilesetMetadata{Name: id, Description: fmt.Sprintf("auto-registered from %s", entry.Name())},
+				ttl:    tileCacheTTL,
+			})
+			log.Printf("tiles: registry: registered PMTiles tileset %q from %s", id, path)
+		}
+	}
+
+	scan()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scan()
+		}
+	}
+}
+
+// ── Built-in Tileset adapters ────────────────────────────────────────────────
+
+// postgisTileset adapts a PostGIS-backed fetch function (e.g. geo.Queries'
+// ForestTile/AdminTile/CadastreTile) to the Tileset interface. When
+// filterFetch is set, the tileset also implements filterableTileset so
+// DynamicTile can honor the ?filter= query parameter for it.
+type postgisTileset struct {
+	fetch       func(ctx context.Context, z, x, y int) ([]byte, error)
+	filterFetch func(ctx context.Context, z, x, y int, f *filter.Filter) ([]byte, error)
+	meta        TilesetMetadata
+	minZoom     int
+	maxZoom     int
+	auth        bool
+	ttl         time.Duration
+}
+
+func (t *postgisTileset) Tile(ctx context.Context, z, x, y int) ([]byte, error) {
+	return t.fetch(ctx, z, x, y)
+}
+func (t *postgisTileset) Metadata() TilesetMetadata { return t.meta }
+func (t *postgisTileset) MinZoom() int              { return t.minZoom }
+func (t *postgisTileset) MaxZoom() int              { return t.maxZoom }
+func (t *postgisTileset) AuthRequired() bool        { return t.auth }
+func (t *postgisTileset) CacheTTL() time.Duration   { return t.ttl }
+
+// TileFiltered fetches a tile restricted by f. Tilesets that never supported
+// filtering (e.g. cadastre, admin-*) fall back to the plain fetch for an
+// empty filter, and reject a non-empty one rather than silently ignoring it.
+func (t *postgisTileset) TileFiltered(ctx context.Context, z, x, y int, f *filter.Filter) ([]byte, error) {
+	if t.filterFetch != nil {
+		return t.filterFetch(ctx, z, x, y, f)
+	}
+	if !f.IsEmpty() {
+		return nil, fmt.Errorf("tileset %q does not support filtering", t.meta.Name)
+	}
+	return t.fetch(ctx, z, x, y)
+}
+
+// pmtilesTileset adapts a PMTilesSource to the Tileset interface.
+type pmtilesTileset struct {
+	source *PMTilesSource
+	meta   TilesetMetadata
+	auth   bool
+	ttl    time.Duration
+}
+
+func (t *pmtilesTileset) Tile(ctx context.Context, z, x, y int) ([]byte, error) {
+	return t.source.Tile(z, x, y)
+}
+func (t *pmtilesTileset) Metadata() TilesetMetadata { return t.meta }
+func (t *pmtilesTileset) MinZoom() int              { return int(t.source.header.MinZoom) }
+func (t *pmtilesTileset) MaxZoom() int              { return int(t.source.header.MaxZoom) }
+func (t *pmtilesTileset) AuthRequired() bool        { return t.auth }
+func (t *pmtilesTileset) CacheTTL() time.Duration   { return t.ttl }
+
+// RegisterBuiltins registers the built-in tilesets (forest, cadastre, and
+// one per admin layer) so they're reachable at /tiles/{id}/{z}/{x}/{y}.mvt —
+// forest, cadastre and admin-* are no longer special-cased as their own
+// routes/handler methods, this is the only way they're served.
+func (h *Handler) RegisterBuiltins() {
+	if h.registry == nil {
+		h.registry = NewTilesetRegistry()
+	}
+
+	h.registry.Register("foret", &postgisTileset{
+		fetch: func(ctx context.Context, z, x, y int) ([]byte, error) {
+			return h.geo.ForestTile(ctx, z, x, y, nil)
+		},
+		filterFetch: func(ctx context.Context, z, x, y int, f *filter.Filter) ([]byte, error) {
+			// Pre-baked PMTiles archives cover only the unfiltered default
+			// view; a filtered request always falls through to PostGIS.
+			if h.forestPMTiles != nil && f.IsEmpty() {
+				tile, err := h.forestPMTiles.Tile(z, x, y)
+				if err != nil {
+					return nil, fmt.Errorf("reading forest PMTiles archive: %w", err)
+				}
+				if tile != nil {
+					return tile, nil
+				}
+				// Archive has no tile at this coordinate (e.g. outside the
+				// pre-baked region) — fall through to PostGIS.
+			}
+			return h.geo.ForestTile(ctx, z, x, y, f)
+		},
+		meta:    TilesetMetadata{Name: "foret", Description: "BD Forêt forest parcels"},
+		minZoom: 0, maxZoom: 22,
+		auth: true,
+		ttl:  tileCacheTTL,
+	})
+	h.registry.Register("cadastre", &postgisTileset{
+		fetch:   h.geo.CadastreTile,
+		meta:    TilesetMetadata{Name: "cadastre", Description: "Cadastre parcelles"},
+		minZoom: 0, maxZoom: 22,
+		auth: true,
+		ttl:  tileCacheTTL,
+	})
+	for _, layer := range []string{"regions", "departements", "communes"} {
+		layer := layer
+		h.registry.Register("admin-"+layer, &postgisTileset{
+			fetch: func(ctx context.Context, z, x, y int) ([]byte, error) {
+				return h.geo.AdminTile(ctx, layer, z, x, y)
+			},
+			meta:    TilesetMetadata{Name: "admin-" + layer, Description: "Administrative boundaries: " + layer},
+			minZoom: 0, maxZoom: 22,
+			auth: false,
+			ttl:  adminTileCacheTTL,
+		})
+	}
+}